@@ -2,6 +2,7 @@ package soyutil;
 
 import (
   "strings"
+  "sync/atomic"
 )
 
 
@@ -33,15 +34,28 @@ func BidiStripHtmlIfNecessary(str string, opt_isHtml bool) string {
  * @return {number} 1 if text is LTR, -1 if it is RTL, and 0 if it is neutral.
  */
 func BidiTextDir(text string, opt_isHtml bool) int {
+  return BidiTextDirWithUrlMode(text, opt_isHtml, BIDI_URLS_DEFAULT)
+};
+
+
+/**
+ * Estimate the overall directionality of text like {@link BidiTextDir}, but
+ * with control over how URL-looking words are counted.
+ * @param {string} text The text whose directionality is to be estimated.
+ * @param {boolean=} opt_isHtml Whether text is HTML/HTML-escaped.
+ * @param {BidiUrlMode} urlMode How to treat URL-looking words.
+ * @return {number} 1 if text is LTR, -1 if it is RTL, and 0 if it is neutral.
+ */
+func BidiTextDirWithUrlMode(text string, opt_isHtml bool, urlMode BidiUrlMode) int {
   text = BidiStripHtmlIfNecessary(text, opt_isHtml);
   if len(text) == 0 {
     return 0
   }
-  if BidiDetectRtlDirectionality(text) {
+  if BidiRtlWordRatioWithUrlMode(text, urlMode) > _BIDI_RTL_DETECTION_THRESHOLD {
     return -1
   }
   return 1
-};
+}
 
 
 /**
@@ -73,6 +87,27 @@ func BidiDirAttr(bidiGlobalDir int, text string, opt_isHtml bool) string {
   return ""
 }
 
+/**
+ * Returns dir="ltr" or dir="rtl" depending on text's estimated directionality,
+ * even when it is the same as bidiGlobalDir. Neutral text gets bidiGlobalDir's
+ * direction, and LTR if that is unknown too.
+ * @param {number} bidiGlobalDir The global directionality context: 1 if ltr, -1
+ *     if rtl, 0 if unknown.
+ * @param {string} text The text whose directionality is to be estimated.
+ * @param {boolean=} isHtml Whether text is HTML/HTML-escaped.
+ * @return {string} "dir=\"rtl\"" or "dir=\"ltr\"".
+ */
+func BidiDirAttrAlways(bidiGlobalDir int, text string, isHtml bool) string {
+  dir := BidiTextDir(text, isHtml)
+  if dir == 0 {
+    dir = bidiGlobalDir
+  }
+  if dir < 0 {
+    return "dir=\"rtl\""
+  }
+  return "dir=\"ltr\""
+}
+
 /**
  * Returns a Unicode BiDi mark matching bidiGlobalDir (LRM or RLM) if the
  * directionality or the exit directionality of text are opposite to
@@ -182,6 +217,65 @@ func BidiUnicodeWrap(bidiGlobalDir int, str string, isHtml bool) string {
 }
 
 
+/**
+ * The global directionality used by the *Default functions: 1 if ltr, -1 if rtl, 0 if unknown.
+ * Accessed atomically so it can be changed while templates render on other goroutines.
+ */
+var defaultBidiGlobalDir int32
+
+/**
+ * Sets the global directionality used by the *Default bidi functions.
+ * @param {number} bidiGlobalDir 1 if ltr, -1 if rtl, 0 if unknown.  Other values are
+ *     normalized by their sign.
+ */
+func SetDefaultBidiDir(bidiGlobalDir int) {
+  var dir int32
+  switch {
+  case bidiGlobalDir > 0:
+    dir = 1
+  case bidiGlobalDir < 0:
+    dir = -1
+  }
+  atomic.StoreInt32(&defaultBidiGlobalDir, dir)
+}
+
+/**
+ * Returns the global directionality used by the *Default bidi functions, 0 until it is set.
+ * @return {number} 1 if ltr, -1 if rtl, 0 if unknown.
+ */
+func GetDefaultBidiDir() int {
+  return int(atomic.LoadInt32(&defaultBidiGlobalDir))
+}
+
+/**
+ * Like {@link BidiDirAttr} using the default global directionality.
+ */
+func BidiDirAttrDefault(text string, opt_isHtml bool) string {
+  return BidiDirAttr(GetDefaultBidiDir(), text, opt_isHtml)
+}
+
+/**
+ * Like {@link BidiMarkAfter} using the default global directionality.
+ */
+func BidiMarkAfterDefault(text string, opt_isHtml bool) string {
+  return BidiMarkAfter(GetDefaultBidiDir(), text, opt_isHtml)
+}
+
+/**
+ * Like {@link BidiSpanWrap} using the default global directionality.
+ */
+func BidiSpanWrapDefault(str string, isHtml bool) string {
+  return BidiSpanWrap(GetDefaultBidiDir(), str, isHtml)
+}
+
+/**
+ * Like {@link BidiUnicodeWrap} using the default global directionality.
+ */
+func BidiUnicodeWrapDefault(str string, isHtml bool) string {
+  return BidiUnicodeWrap(GetDefaultBidiDir(), str, isHtml)
+}
+
+
 /**
  * Check the directionality of the a piece of text based on the first character
  * with strong directionality.
@@ -213,14 +307,29 @@ func BidiIsNeutralText(str string) bool {
  * @private
  */
 func BidiRtlWordRatio(str string) float64 {
+  return BidiRtlWordRatioWithUrlMode(str, BIDI_URLS_DEFAULT)
+}
+
+
+/**
+ * Returns the RTL ratio based on word count, skipping URL-looking words when
+ * urlMode is BIDI_URLS_NEUTRAL.
+ * @param {string} str the string that need to be checked.
+ * @param {BidiUrlMode} urlMode How to treat URL-looking words.
+ * @return {number} the ratio of RTL words among all words with directionality.
+ */
+func BidiRtlWordRatioWithUrlMode(str string, urlMode BidiUrlMode) float64 {
   rtlCount := 0
   totalCount := 0
   tokens := strings.SplitN(str, " ", -1)
   for _, token := range tokens {
+    if urlMode == BIDI_URLS_NEUTRAL && _BIDI_URL_CHECK_RE.MatchString(token) {
+      continue
+    }
     if BidiIsRtlText(token) {
       rtlCount++
       totalCount++
-    } else if BidiIsNeutralText(token) {
+    } else if !BidiIsNeutralText(token) {
       totalCount++
     }
   }
@@ -231,6 +340,36 @@ func BidiRtlWordRatio(str string) float64 {
 }
 
 
+/**
+ * Returns the RTL ratio based on character count, which unlike the word ratio does not depend
+ * on the text being separated by spaces.
+ * @param {string} str the string that need to be checked.
+ * @return {number} the ratio of strong RTL characters among all strongly directional
+ *     characters.
+ */
+func BidiRtlCharRatio(str string) float64 {
+  rtlCount := len(_BIDI_RTL_CHAR_RE.FindAllStringIndex(str, -1))
+  totalCount := rtlCount + len(_BIDI_LTR_CHAR_RE.FindAllStringIndex(str, -1))
+  if totalCount == 0 {
+    return 0
+  }
+  return float64(rtlCount) / float64(totalCount)
+}
+
+
+/**
+ * Check whether a piece of text contains both strong LTR and strong RTL
+ * characters, which usually means it should be isolated when displayed.
+ * @param {string} str The piece of text that need to be checked.
+ * @param {boolean=} isHtml Whether str is HTML / HTML-escaped.
+ * @return {boolean} true if both LTR and RTL characters are present.
+ */
+func BidiIsMixed(str string, isHtml bool) bool {
+  str = BidiStripHtmlIfNecessary(str, isHtml)
+  return _BIDI_LTR_CHAR_RE.MatchString(str) && _BIDI_RTL_CHAR_RE.MatchString(str)
+}
+
+
 /**
  * Check the directionality of a piece of text, return true if the piece of
  * text should be laid out in RTL direction.
@@ -243,6 +382,21 @@ func BidiDetectRtlDirectionality(str string) bool {
 }
 
 
+/**
+ * Like {@link BidiDetectRtlDirectionality} but lets the caller choose whether words or
+ * characters are counted.
+ * @param {string} str The piece of text that need to be detected.
+ * @param {BidiRatioMode} ratioMode What to count when estimating the RTL ratio.
+ * @return {boolean} true if this piece of text should be laid out in RTL.
+ */
+func BidiDetectRtlDirectionalityWithMode(str string, ratioMode BidiRatioMode) bool {
+  if ratioMode == BIDI_RATIO_CHARS {
+    return BidiRtlCharRatio(str) > _BIDI_RTL_DETECTION_THRESHOLD
+  }
+  return BidiDetectRtlDirectionality(str)
+}
+
+
 /**
  * Check if the exit directionality a piece of text is LTR, i.e. if the last
  * strongly-directional character in the string is LTR.
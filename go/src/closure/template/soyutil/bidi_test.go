@@ -0,0 +1,60 @@
+package soyutil_test;
+
+import (
+  . "closure/template/soyutil"
+  "testing"
+)
+
+
+func TestBidiIsMixed(t *testing.T) {
+  assertBoolEquals(t, false, BidiIsMixed("hello world", false), "pure LTR")
+  assertBoolEquals(t, false, BidiIsMixed("שלום עולם", false), "pure RTL")
+  assertBoolEquals(t, true, BidiIsMixed("hello שלום", false), "mixed")
+  assertBoolEquals(t, false, BidiIsMixed("<b class=x>שלום</b>", true), "RTL inside markup")
+  assertBoolEquals(t, false, BidiIsMixed("123 !?", false), "neutral")
+}
+
+func TestBidiTextDirWithUrlMode(t *testing.T) {
+  text := "שלום https://example.com/a www.example.com/b"
+  assertFloat64Equals(t, 1.0 / 3, BidiRtlWordRatio(text), "BidiRtlWordRatio counts URLs as LTR words")
+  assertFloat64Equals(t, 1, BidiRtlWordRatioWithUrlMode(text, BIDI_URLS_NEUTRAL), "BIDI_URLS_NEUTRAL skips URLs")
+  assertIntEquals(t, 1, BidiTextDir(text, false), "BidiTextDir with embedded URLs")
+  assertIntEquals(t, 1, BidiTextDirWithUrlMode(text, false, BIDI_URLS_DEFAULT), "BIDI_URLS_DEFAULT with embedded URLs")
+  assertIntEquals(t, -1, BidiTextDirWithUrlMode(text, false, BIDI_URLS_NEUTRAL), "BIDI_URLS_NEUTRAL with embedded URLs")
+  assertIntEquals(t, -1, BidiTextDirWithUrlMode("שלום עולם", false, BIDI_URLS_NEUTRAL), "BIDI_URLS_NEUTRAL without URLs")
+}
+
+func TestBidiDirAttrAlways(t *testing.T) {
+  assertStringEquals(t, "", BidiDirAttr(1, "hello", false), "BidiDirAttr with a matching direction")
+  assertStringEquals(t, "dir=\"ltr\"", BidiDirAttrAlways(1, "hello", false), "BidiDirAttrAlways with a matching direction")
+  assertStringEquals(t, "dir=\"rtl\"", BidiDirAttrAlways(1, "שלום", false), "BidiDirAttrAlways with a non-matching direction")
+  assertStringEquals(t, "dir=\"rtl\"", BidiDirAttrAlways(-1, "שלום", false), "BidiDirAttrAlways with a matching RTL direction")
+  assertStringEquals(t, "dir=\"rtl\"", BidiDirAttrAlways(-1, "", false), "BidiDirAttrAlways with neutral text")
+}
+
+func TestDefaultBidiDir(t *testing.T) {
+  defer SetDefaultBidiDir(GetDefaultBidiDir())
+  SetDefaultBidiDir(-1)
+  assertIntEquals(t, -1, GetDefaultBidiDir(), "GetDefaultBidiDir after setting RTL")
+  assertStringEquals(t, BidiSpanWrap(-1, "hello", false), BidiSpanWrapDefault("hello", false), "BidiSpanWrapDefault with RTL")
+  assertStringEquals(t, "<span dir=\"ltr\">hello</span>\u200F", BidiSpanWrapDefault("hello", false), "BidiSpanWrapDefault wraps LTR text")
+  assertStringEquals(t, "שלום", BidiSpanWrapDefault("שלום", false), "BidiSpanWrapDefault leaves RTL text")
+  assertStringEquals(t, "\u202Ahello\u202C\u200F", BidiUnicodeWrapDefault("hello", false), "BidiUnicodeWrapDefault with RTL")
+  assertStringEquals(t, "dir=ltr", BidiDirAttrDefault("hello", false), "BidiDirAttrDefault with RTL")
+  SetDefaultBidiDir(5)
+  assertIntEquals(t, 1, GetDefaultBidiDir(), "SetDefaultBidiDir normalizes by sign")
+  assertStringEquals(t, "hello", BidiSpanWrapDefault("hello", false), "BidiSpanWrapDefault with LTR")
+}
+
+func TestBidiRtlCharRatio(t *testing.T) {
+  assertFloat64Equals(t, 1, BidiRtlCharRatio("مرحبابالعالم"), "BidiRtlCharRatio with RTL text and no spaces")
+  assertFloat64Equals(t, 0.5, BidiRtlCharRatio("abשל"), "BidiRtlCharRatio with mixed text")
+  assertFloat64Equals(t, 0, BidiRtlCharRatio("123 !?"), "BidiRtlCharRatio with neutral text")
+  assertBoolEquals(t, true, BidiDetectRtlDirectionalityWithMode("abcمرحبابالعالم", BIDI_RATIO_CHARS), "char ratio detects RTL without spaces")
+  assertBoolEquals(t, false, BidiDetectRtlDirectionalityWithMode("abcdefghijשלום", BIDI_RATIO_CHARS), "char ratio detects mostly LTR text")
+  assertFloat64Equals(t, 0.5, BidiRtlWordRatio("abc مرحبا"), "BidiRtlWordRatio with one LTR and one RTL word")
+  assertFloat64Equals(t, 0, BidiRtlWordRatio("abcمرحبابالعالم"), "BidiRtlWordRatio with an LTR-first word")
+  assertFloat64Equals(t, 1, BidiRtlWordRatio("مرحبا 123"), "BidiRtlWordRatio ignores neutral words")
+  assertBoolEquals(t, false, BidiDetectRtlDirectionalityWithMode("abcمرحبابالعالم", BIDI_RATIO_WORDS), "word ratio misses RTL without spaces")
+  assertBoolEquals(t, false, BidiDetectRtlDirectionality("abcمرحبابالعالم"), "BidiDetectRtlDirectionality counts words")
+}
@@ -42,6 +42,38 @@ const (
 
 )
 
+/**
+ * How URL-looking tokens are treated when estimating directionality.
+ */
+type BidiUrlMode int
+
+const (
+  /** URLs are classified like any other word. */
+  BIDI_URLS_DEFAULT BidiUrlMode = iota
+
+  /**
+   * Tokens starting with http://, https://, or www. are treated as neutral and ignored, so URLs
+   * embedded in RTL text do not tip the word ratio towards LTR.
+   */
+  BIDI_URLS_NEUTRAL
+)
+
+/**
+ * What is counted when estimating the ratio of RTL text.
+ */
+type BidiRatioMode int
+
+const (
+  /** Counts whole space separated words, see BidiRtlWordRatio. */
+  BIDI_RATIO_WORDS BidiRatioMode = iota
+
+  /**
+   * Counts strongly directional characters, see BidiRtlCharRatio.  Better suited to text
+   * that is not separated by spaces.
+   */
+  BIDI_RATIO_CHARS
+)
+
 type ContentKind int
 
 const (
@@ -71,6 +103,12 @@ const (
 
   /** An attribute name and value, such as {@code dir="ltr"}. */
   CONTENT_KIND_HTML_ATTRIBUTE
+
+  /**
+   * A URI that is trusted to load code or other resources, such as a script {@code src}, a
+   * stylesheet {@code href} or an iframe {@code src}.
+   */
+  CONTENT_KIND_TRUSTED_RESOURCE_URI
 )
 
 func (p ContentKind) String() string {
@@ -79,12 +117,35 @@ func (p ContentKind) String() string {
     return "HTML"
   case CONTENT_KIND_JS_STR_CHARS:
     return "JS_STR_CHARS"
+  case CONTENT_KIND_URI:
+    return "URI"
   case CONTENT_KIND_HTML_ATTRIBUTE:
     return "HTML_ATTRIBUTE"
+  case CONTENT_KIND_TRUSTED_RESOURCE_URI:
+    return "TRUSTED_RESOURCE_URI"
   }
   return "UNKNOWN_CONTENT_KIND"
 }
 
+/**
+ * How {@link RoundWithMode} breaks ties and rounds fractions.
+ */
+type RoundMode int
+
+const (
+  /** Round half away from zero, as {@link Round} does: 2.5 -> 3, -2.5 -> -3. */
+  ROUND_HALF_UP RoundMode = iota
+
+  /** Round half to the nearest even number (banker's rounding): 2.5 -> 2, 3.5 -> 4. */
+  ROUND_HALF_EVEN
+
+  /** Round towards negative infinity. */
+  ROUND_FLOOR
+
+  /** Round towards positive infinity. */
+  ROUND_CEIL
+)
+
 var (
   /**
    * Simplified regular expression for am HTML tag (opening or closing) or an HTML
@@ -127,6 +188,27 @@ var (
    */
   _BIDI_RTL_EXIT_DIR_CHECK_RE *regexp.Regexp
 
+  /**
+   * Regular expression matching any strong LTR character.
+   * @type {RegExp}
+   * @private
+   */
+  _BIDI_LTR_CHAR_RE *regexp.Regexp
+
+  /**
+   * Regular expression matching any strong RTL character.
+   * @type {RegExp}
+   * @private
+   */
+  _BIDI_RTL_CHAR_RE *regexp.Regexp
+
+  /**
+   * Regular expression to check if a token looks like a URL.
+   * @type {RegExp}
+   * @private
+   */
+  _BIDI_URL_CHECK_RE *regexp.Regexp
+
   /**
    * Regular expression used within $$changeNewlineToBr().
    * @type {RegExp}
@@ -135,14 +217,20 @@ var (
   _CHANGE_NEWLINE_TO_BR_RE *regexp.Regexp
 
   _CHANGE_NEWLINE_TO_BR2_RE *regexp.Regexp
-  
-  
+
+  /**
+   * Regular expression matching an HTML tag, DOCTYPE, or comment at the start of a string.
+   * @type {RegExp}
+   * @private
+   */
+  _HTML_TAG_PREFIX_RE *regexp.Regexp
+
   /**
-   * Regular expression used for determining if a string needs to be encoded.
+   * Regular expression matching a named or numeric HTML entity at the start of a string.
    * @type {RegExp}
    * @private
    */
-  _ENCODE_URI_RE *regexp.Regexp
+  _HTML_ENTITY_PREFIX_RE *regexp.Regexp
   
   
   /**
@@ -160,9 +248,13 @@ func init() {
   _BIDI_NEUTRAL_DIR_CHECK_RE, _ = regexp.Compile("^[" + _BIDI_NEUTRAL_CHARS + "]*$|^http://")
   _BIDI_LTR_EXIT_DIR_CHECK_RE, _ = regexp.Compile("[" + _BIDI_LTR_CHARS + "][^" + _BIDI_RTL_CHARS + "]*$")
   _BIDI_RTL_EXIT_DIR_CHECK_RE, _ = regexp.Compile("[" + _BIDI_RTL_CHARS + "][^" + _BIDI_LTR_CHARS + "]*$")
+  _BIDI_LTR_CHAR_RE, _ = regexp.Compile("[" + _BIDI_LTR_CHARS + "]")
+  _BIDI_RTL_CHAR_RE, _ = regexp.Compile("[" + _BIDI_RTL_CHARS + "]")
+  _BIDI_URL_CHECK_RE, _ = regexp.Compile("(?i)^(?:https?://|www\\.)")
   _CHANGE_NEWLINE_TO_BR_RE, _ = regexp.Compile("[\r\n]")
   _CHANGE_NEWLINE_TO_BR2_RE, _ = regexp.Compile("(\r\n|\r|\n)")
-  _ENCODE_URI_RE, _ = regexp.Compile("^[a-zA-Z0-9\\-_.!~*'()]*$")
+  _HTML_TAG_PREFIX_RE, _ = regexp.Compile("^<(?:!|/?[a-zA-Z])(?:[^>'\"]|\"[^\"]*\"|'[^']*')*>")
+  _HTML_ENTITY_PREFIX_RE, _ = regexp.Compile("^&(?:#[0-9]+|#[xX][0-9a-fA-F]+|[a-zA-Z][a-zA-Z0-9]*);")
   _EscapeCharJs = map[string]string{
     "\b": "\\b",
     "\f": "\\f",
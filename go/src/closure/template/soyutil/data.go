@@ -1,10 +1,17 @@
 package soyutil;
 
 import (
+  "bytes"
   "container/list"
+  "encoding/json"
   "fmt"
+  "io"
+  "math"
+  "sort"
   "strconv"
+  "strings"
   "reflect"
+  "unicode/utf16"
 )
 
 var NilDataInstance = &NilData{}
@@ -17,6 +24,10 @@ type Stringer interface {
   String() string
 }
 
+type HashCoder interface {
+  HashCode() int
+}
+
 type SoyDataException struct {
   msg string
 }
@@ -175,12 +186,23 @@ func (p NilData) String() string {
   return "null"
 }
 
+func (p NilData) MarshalJSON() ([]byte, error) {
+  return []byte("null"), nil
+}
+
 func (p NilData) Bool() bool {
   return false
 }
 
+/**
+ * Null is equal to nil, to null and to undefined, as with '==' in JavaScript.
+ */
 func (p NilData) Equals(other interface{}) bool {
-  return p == other || other == nil
+  switch other.(type) {
+  case nil, NilData, *NilData, UndefinedData, *UndefinedData:
+    return true
+  }
+  return false
 }
 
 func (p NilData) HashCode() int {
@@ -251,6 +273,65 @@ func (p NilData) Remove(e *list.Element) SoyData {
   return p
 }
 
+func (p NilData) RemoveValue(v SoyData) bool {
+  return false
+}
+
+func (p NilData) RemoveAt(index int) SoyData {
+  return NilDataInstance
+}
+
+func (p NilData) SetAt(index int, value SoyData) error {
+  return nil
+}
+
+
+/**
+ * The value of a key that is not present, as opposed to NilData which is a null that was
+ * explicitly stored.  It behaves like NilData except that it prints as "undefined" and
+ * IsUndefined reports it.
+ */
+type UndefinedData struct {
+  NilData
+}
+
+var UndefinedDataInstance = &UndefinedData{}
+
+func (p UndefinedData) StringValue() (string) {
+  return "undefined"
+}
+
+func (p UndefinedData) String() string {
+  return "undefined"
+}
+
+func (p UndefinedData) SoyData() SoyData {
+  return UndefinedDataInstance
+}
+
+/**
+ * Whether d is nil, a stored null or undefined.  The last two also implement SoyListData.
+ */
+func isNullData(d interface{}) bool {
+  switch d.(type) {
+  case nil, NilData, *NilData, UndefinedData, *UndefinedData:
+    return true
+  }
+  return false
+}
+
+/**
+ * Whether d is the result of looking up something that is not present, rather than a stored
+ * null or any other value.
+ */
+func IsUndefined(d SoyData) bool {
+  switch d.(type) {
+  case UndefinedData, *UndefinedData:
+    return true
+  }
+  return false
+}
+
 
 type BooleanData bool
 
@@ -309,12 +390,17 @@ func (p BooleanData) Bool() bool {
   return bool(p)
 }
 
+/**
+ * Compares using Soy's loose '==' semantics: when other is any SoyData it is coerced to a
+ * boolean via Bool(), so false == 0 and false == "" are both true, as is true == "x".
+ * Use {@link StrictBoolEquals} when only actual booleans should compare equal.
+ */
 func (p BooleanData) Equals(other interface{}) bool {
   if other == nil {
     return false
   }
   switch o := other.(type) {
-  case *NilData:
+  case *NilData, *UndefinedData:
     return false;
   case bool:
     return bool(p) == o
@@ -324,6 +410,29 @@ func (p BooleanData) Equals(other interface{}) bool {
   return false
 }
 
+/**
+ * Compares two values for boolean equality without any truthiness coercion.
+ * @return True only if both values are BooleanData (or Go bools) with the same value.
+ */
+func StrictBoolEquals(a, b interface{}) bool {
+  av, ok := strictBoolValue(a)
+  if !ok {
+    return false
+  }
+  bv, ok := strictBoolValue(b)
+  return ok && av == bv
+}
+
+func strictBoolValue(v interface{}) (bool, bool) {
+  switch o := v.(type) {
+  case BooleanData:
+    return bool(o), true
+  case bool:
+    return o, true
+  }
+  return false, false
+}
+
 func (p BooleanData) HashCode() int {
   if p {
     return 1
@@ -367,7 +476,7 @@ func (p IntegerData) NumberValue() (float64) {
 }
 
 func (p IntegerData) StringValue() (string) {
-  return string(p.Value())
+  return strconv.Itoa(p.Value())
 }
 
 func (p IntegerData) String() string {
@@ -383,7 +492,7 @@ func (p IntegerData) Equals(other interface{}) bool {
     return false
   }
   switch o := other.(type) {
-  case *NilData:
+  case *NilData, *UndefinedData:
     return false;
   case int:
     return int(p) == o
@@ -395,6 +504,8 @@ func (p IntegerData) Equals(other interface{}) bool {
     return float64(p) == float64(o)
   case float64:
     return float64(p) == o
+  case IntegerData, Int64Data, Float64Data:
+    return NumbersEqual(p, o.(SoyData))
   case SoyData:
     return int(p) == o.IntegerValue()
   }
@@ -410,6 +521,86 @@ func (p IntegerData) SoyData() SoyData {
 }
 
 
+/**
+ * An integer that keeps all 64 bits regardless of the size of int, e.g. for IDs from a
+ * database.  It behaves like IntegerData in comparisons and arithmetic.
+ */
+type Int64Data int64
+
+func NewInt64Data(value int64) Int64Data {
+  return Int64Data(value)
+}
+
+func (p Int64Data) Value() int64 {
+  return int64(p)
+}
+
+func (p Int64Data) BooleanValue() (bool) {
+  return p.Value() != 0
+}
+
+func (p Int64Data) IntegerValue() (int) {
+  return int(p.Value())
+}
+
+func (p Int64Data) FloatValue() (float32) {
+  return float32(p.Value())
+}
+
+func (p Int64Data) Float64Value() (float64) {
+  return float64(p.Value())
+}
+
+func (p Int64Data) NumberValue() (float64) {
+  return float64(p.Value())
+}
+
+func (p Int64Data) StringValue() (string) {
+  return strconv.FormatInt(p.Value(), 10)
+}
+
+func (p Int64Data) String() string {
+  return strconv.FormatInt(p.Value(), 10)
+}
+
+func (p Int64Data) Bool() bool {
+  return p.Value() != 0
+}
+
+func (p Int64Data) Equals(other interface{}) bool {
+  if other == nil {
+    return false
+  }
+  switch o := other.(type) {
+  case *NilData, *UndefinedData:
+    return false;
+  case int:
+    return int64(p) == int64(o)
+  case int32:
+    return int64(p) == int64(o)
+  case int64:
+    return int64(p) == o
+  case float32:
+    return float64(p) == float64(o)
+  case float64:
+    return float64(p) == o
+  case IntegerData, Int64Data, Float64Data:
+    return NumbersEqual(p, o.(SoyData))
+  case SoyData:
+    return int64(p) == int64(o.IntegerValue())
+  }
+  return false
+}
+
+func (p Int64Data) HashCode() int {
+  return int(p)
+}
+
+func (p Int64Data) SoyData() SoyData {
+  return p
+}
+
+
 type Float64Data float64
 
 func NewFloat64Data(value float64) Float64Data {
@@ -457,7 +648,7 @@ func (p Float64Data) Equals(other interface{}) bool {
     return false
   }
   switch o := other.(type) {
-  case *NilData:
+  case *NilData, *UndefinedData:
     return false;
   case int:
     return float64(p) == float64(o)
@@ -469,12 +660,45 @@ func (p Float64Data) Equals(other interface{}) bool {
     return float64(p) == float64(o)
   case float64:
     return float64(p) == o
+  case IntegerData, Int64Data, Float64Data:
+    return NumbersEqual(p, o.(SoyData))
   case SoyData:
     return float64(p) == o.Float64Value()
   }
   return false
 }
 
+/**
+ * Returns the value of an IntegerData or Int64Data, and false for any other type.
+ */
+func integralValue(d SoyData) (int64, bool) {
+  switch v := d.(type) {
+  case IntegerData:
+    return int64(v), true
+  case Int64Data:
+    return int64(v), true
+  }
+  return 0, false
+}
+
+/**
+ * Whether a and b are both numbers with the same value, so that 3 == 3.0 regardless of which
+ * side is the integer.  Two integers are compared exactly, anything else as float64.
+ */
+func NumbersEqual(a, b SoyData) bool {
+  ai, aIsInt := integralValue(a)
+  bi, bIsInt := integralValue(b)
+  if aIsInt && bIsInt {
+    return ai == bi
+  }
+  _, aIsFloat := a.(Float64Data)
+  _, bIsFloat := b.(Float64Data)
+  if (aIsInt || aIsFloat) && (bIsInt || bIsFloat) {
+    return a.Float64Value() == b.Float64Value()
+  }
+  return false
+}
+
 func (p Float64Data) HashCode() int {
   return int(p)
 }
@@ -495,23 +719,40 @@ func (p StringData) Value() string {
 }
 
 func (p StringData) BooleanValue() (bool) {
-  return defaultBooleanValue()
+  return len(p) > 0
 }
 
+/**
+ * Parses the string as an integer, truncating a floating point value, so that numeric params
+ * passed as strings can take part in arithmetic.  Returns 0 if the string is not numeric.
+ */
 func (p StringData) IntegerValue() (int) {
+  s := strings.TrimSpace(string(p))
+  if i, err := strconv.Atoi(s); err == nil {
+    return i
+  }
+  if f, err := strconv.ParseFloat(s, 64); err == nil {
+    return int(f)
+  }
   return defaultIntegerValue()
 }
 
 func (p StringData) FloatValue() (float32) {
-  return defaultFloatValue()
+  return float32(p.Float64Value())
 }
 
+/**
+ * Parses the string as a number.  Returns 0 if the string is not numeric.
+ */
 func (p StringData) Float64Value() (float64) {
+  if f, err := strconv.ParseFloat(strings.TrimSpace(string(p)), 64); err == nil {
+    return f
+  }
   return defaultFloat64Value()
 }
 
 func (p StringData) NumberValue() (float64) {
-  return defaultNumberValue()
+  return p.Float64Value()
 }
 
 func (p StringData) StringValue() (string) {
@@ -535,7 +776,7 @@ func (p StringData) Equals(other interface{}) bool {
     return false
   }
   switch o := other.(type) {
-  case *NilData:
+  case *NilData, *UndefinedData:
     return false;
   case string:
     return string(p) == o
@@ -545,9 +786,21 @@ func (p StringData) Equals(other interface{}) bool {
   return false
 }
 
+/**
+ * Returns the same hash as Java's String.hashCode(), computed over UTF-16 code units.
+ */
 func (p StringData) HashCode() int {
-  // todo create efficient string hashcode function
-  return 123
+  var h int32
+  for _, c := range string(p) {
+    if c >= 0x10000 {
+      r1, r2 := utf16.EncodeRune(c)
+      h = 31 * h + int32(r1)
+      h = 31 * h + int32(r2)
+    } else {
+      h = 31 * h + int32(c)
+    }
+  }
+  return int(h)
 }
 
 func (p StringData) SoyData() SoyData {
@@ -572,6 +825,9 @@ type SoyListData interface {
   PushFront(value SoyData) *list.Element
   PushFrontList(ol SoyListData)
   Remove(e *list.Element) SoyData
+  RemoveAt(index int) SoyData
+  RemoveValue(v SoyData) bool
+  SetAt(index int, value SoyData) error
 }
 
 type soyListData struct {
@@ -662,22 +918,27 @@ func (p *soyListData) Equals(other interface{}) bool {
   if other == nil {
     return false
   }
+  switch other.(type) {
+  case NilData, *NilData, UndefinedData, *UndefinedData:
+    // These implement SoyListData but are not lists.
+    return false
+  }
   if o, ok := other.(SoyListData); ok {
     if p.Len() != o.Len() {
       return false
     }
     for oe, pe := o.Front(), p.Front(); oe != nil && pe != nil; oe, pe = oe.Next(), pe.Next() {
-      if oe.Value == pe.Value {
+      pv, _ := pe.Value.(SoyData)
+      ov, _ := oe.Value.(SoyData)
+      if pv == nil || ov == nil {
+        if pv != ov {
+          return false
+        }
         continue
       }
-      if oe.Value != nil {
-        if e, ok := oe.Value.(Equalser); ok {
-          if e.Equals(pe.Value) {
-            continue
-          }
-        }
+      if !pv.Equals(ov) {
+        return false
       }
-      return false
     }
     return true
   }
@@ -688,6 +949,25 @@ func (p *soyListData) SoyData() SoyData {
   return p
 }
 
+/**
+ * Encodes the list as a JSON array in element order.
+ */
+func (p *soyListData) MarshalJSON() ([]byte, error) {
+  buf := bytes.NewBufferString("[")
+  for e := p.l.Front(); e != nil; e = e.Next() {
+    if e != p.l.Front() {
+      buf.WriteByte(',')
+    }
+    v, err := MarshalSoyData(e.Value.(SoyData))
+    if err != nil {
+      return nil, err
+    }
+    buf.Write(v)
+  }
+  buf.WriteByte(']')
+  return buf.Bytes(), nil
+}
+
 func (p *soyListData) At(index int) SoyData {
   e := p.l.Front()
   for i := 0; i < index && e != nil; i++ {
@@ -778,6 +1058,104 @@ func (p *soyListData) Remove(e *list.Element) SoyData {
   return p.l.Remove(e).(SoyData)
 }
 
+/**
+ * Removes the first element that Equals v.
+ * @return Whether an element was removed.
+ */
+func (p *soyListData) RemoveValue(v SoyData) bool {
+  if v == nil {
+    v = NilDataInstance
+  }
+  for e := p.l.Front(); e != nil; e = e.Next() {
+    if e.Value.(SoyData).Equals(v) {
+      p.l.Remove(e)
+      return true
+    }
+  }
+  return false
+}
+
+func (p *soyListData) elementAt(index int) *list.Element {
+  if index < 0 || index >= p.l.Len() {
+    return nil
+  }
+  e := p.l.Front()
+  for i := 0; i < index; i++ {
+    e = e.Next()
+  }
+  return e
+}
+
+/**
+ * Removes the element at index.
+ * @return The removed value, or NilDataInstance if index is out of range.
+ */
+func (p *soyListData) RemoveAt(index int) SoyData {
+  e := p.elementAt(index)
+  if e == nil {
+    return NilDataInstance
+  }
+  return p.l.Remove(e).(SoyData)
+}
+
+/**
+ * Replaces the value at index, which must already be in the list.
+ */
+func (p *soyListData) SetAt(index int, value SoyData) error {
+  e := p.elementAt(index)
+  if e == nil {
+    return NewSoyDataException(fmt.Sprintf("Index %d is out of range for a list of length %d", index, p.l.Len()))
+  }
+  if value == nil {
+    value = NilDataInstance
+  }
+  e.Value = value
+  return nil
+}
+
+/**
+ * Returns the elements of the list in order, e.g. to pass back to NewSoyListDataFromVector.
+ */
+func (p *soyListData) ToSlice() []SoyData {
+  arr := make([]SoyData, 0, p.l.Len())
+  for e := p.l.Front(); e != nil; e = e.Next() {
+    arr = append(arr, e.Value.(SoyData))
+  }
+  return arr
+}
+
+/**
+ * Returns the elements of any SoyListData in order; a nil list gives an empty slice.
+ */
+func SoyListDataToSlice(l SoyListData) []SoyData {
+  if l == nil {
+    return []SoyData{}
+  }
+  if sl, ok := l.(*soyListData); ok {
+    return sl.ToSlice()
+  }
+  arr := make([]SoyData, 0, l.Len())
+  for e := l.Front(); e != nil; e = e.Next() {
+    arr = append(arr, e.Value.(SoyData))
+  }
+  return arr
+}
+
+/**
+ * Converts a list to a slice of strings, coercing each element with String() the same way a
+ * template does when it prints the element.
+ */
+func ListToStrings(l SoyListData) []string {
+  if l == nil {
+    return []string{}
+  }
+  arr := make([]string, 0, l.Len())
+  for e := l.Front(); e != nil; e = e.Next() {
+    arr = append(arr, e.Value.(SoyData).String())
+  }
+  return arr
+}
+
 
 type SoyMapData map[string]SoyData
 
@@ -856,7 +1234,7 @@ func (p SoyMapData) Len() int {
 func (p SoyMapData) Get(key string) SoyData {
   value, ok := p[key]
   if !ok {
-    return NilDataInstance
+    return UndefinedDataInstance
   }
   return value
 }
@@ -880,6 +1258,20 @@ func (p SoyMapData) Set(key string, value SoyData) {
   p[key] = value
 }
 
+func (p SoyMapData) Delete(key string) {
+  delete(p, key)
+}
+
+/**
+ * Returns the keys in lexicographic order, unlike Keys() whose order is random, so iterating
+ * over a map gives the same output on every render.
+ */
+func (p SoyMapData) SortedKeys() []string {
+  keys := p.Keys()
+  sort.Strings(keys)
+  return keys
+}
+
 func (p SoyMapData) Bool() bool {
   return len(p) > 0
 }
@@ -892,19 +1284,68 @@ func (p SoyMapData) Equals(other interface{}) bool {
   if other == nil {
     return false
   }
-  if o, ok := other.(SoyMapData); ok && &p == &o {
-    return true
+  if o, ok := other.(*OrderedSoyMapData); ok {
+    // Compare with the entries of an ordered map so that equality is symmetric.
+    if o == nil {
+      return false
+    }
+    other = o.m
   }
   if o, ok := other.(SoyMapData); ok {
+    if reflect.ValueOf(p).Pointer() == reflect.ValueOf(o).Pointer() {
+      // Same underlying map.
+      return true
+    }
     if len(p) != len(o) {
       return false
     }
-    // TODO check each element
+    for k, v := range p {
+      ov, found := o[k]
+      if !found {
+        return false
+      }
+      if v == nil || ov == nil {
+        if v != ov {
+          return false
+        }
+        continue
+      }
+      if !v.Equals(ov) {
+        return false
+      }
+    }
     return true
   }
   return false
 }
 
+/**
+ * Encodes the map as a JSON object. Keys are always written in sorted order so the output is
+ * byte-for-byte stable across runs, which caching and snapshot tests depend on.
+ */
+func (p SoyMapData) MarshalJSON() ([]byte, error) {
+  keys := p.SortedKeys()
+  buf := bytes.NewBufferString("{")
+  for i, key := range keys {
+    if i > 0 {
+      buf.WriteByte(',')
+    }
+    k, err := json.Marshal(key)
+    if err != nil {
+      return nil, err
+    }
+    buf.Write(k)
+    buf.WriteByte(':')
+    v, err := MarshalSoyData(p[key])
+    if err != nil {
+      return nil, err
+    }
+    buf.Write(v)
+  }
+  buf.WriteByte('}')
+  return buf.Bytes(), nil
+}
+
 func (p SoyMapData) SoyData() SoyData {
   return p
 }
@@ -917,8 +1358,527 @@ func (p SoyMapData) IsEmpty() bool {
   return len(p) == 0
 }
 
+/**
+ * Calls fn for each entry in no particular order, stopping early if fn returns false.  Use
+ * SortedKeys or OrderedSoyMapData when the order matters.
+ */
+func (p SoyMapData) ForEach(fn func(key string, value SoyData) bool) {
+  for k, v := range p {
+    if !fn(k, v) {
+      return
+    }
+  }
+}
+
+
+/**
+ * The read methods shared by SoyMapData and OrderedSoyMapData.
+ */
+type SoyMap interface {
+  SoyData
+  Contains(key string) bool
+  ForEach(fn func(key string, value SoyData) bool)
+  Get(key string) SoyData
+  Keys() []string
+  Len() int
+}
+
+var _ SoyMap = SoyMapData(nil)
+var _ SoyMap = (*OrderedSoyMapData)(nil)
+
+/**
+ * A map that remembers the order in which keys were first set, so that Keys(), ForEach and
+ * MarshalJSON give the same order on every render.  Setting an existing key keeps its position.
+ */
+type OrderedSoyMapData struct {
+  keys []string
+  m SoyMapData
+}
+
+func NewOrderedSoyMapData() *OrderedSoyMapData {
+  return &OrderedSoyMapData{m:make(SoyMapData)}
+}
+
+/**
+ * Creates an ordered map from alternating keys and values, in the same form as
+ * NewSoyMapDataFromArgs, keeping the keys in argument order.
+ */
+func NewOrderedSoyMapDataFromPairs(args ...interface{}) *OrderedSoyMapData {
+  p := NewOrderedSoyMapData()
+  isKey := true
+  var key string
+  for _, arg := range args {
+    if isKey {
+      sdk, err := ToSoyData(arg)
+      if err != nil {
+        return nil
+      }
+      key = sdk.String()
+    } else {
+      value, err := ToSoyData(arg)
+      if err != nil {
+        return nil
+      }
+      p.Set(key, value)
+    }
+    isKey = !isKey
+  }
+  return p
+}
+
+func (p *OrderedSoyMapData) BooleanValue() (bool) {
+  return defaultBooleanValue()
+}
+
+func (p *OrderedSoyMapData) IntegerValue() (int) {
+  return defaultIntegerValue()
+}
+
+func (p *OrderedSoyMapData) FloatValue() (float32) {
+  return defaultFloatValue()
+}
+
+func (p *OrderedSoyMapData) Float64Value() (float64) {
+  return defaultFloat64Value()
+}
+
+func (p *OrderedSoyMapData) NumberValue() (float64) {
+  return defaultNumberValue()
+}
+
+func (p *OrderedSoyMapData) StringValue() (string) {
+  return defaultStringValue()
+}
+
+func (p *OrderedSoyMapData) Len() int {
+  return len(p.keys)
+}
+
+func (p *OrderedSoyMapData) Get(key string) SoyData {
+  return p.m.Get(key)
+}
+
+func (p *OrderedSoyMapData) Contains(key string) bool {
+  return p.m.Contains(key)
+}
+
+/**
+ * Returns the keys in insertion order.
+ */
+func (p *OrderedSoyMapData) Keys() []string {
+  arr := make([]string, len(p.keys))
+  copy(arr, p.keys)
+  return arr
+}
+
+func (p *OrderedSoyMapData) Set(key string, value SoyData) {
+  if value == nil {
+    value = NilDataInstance
+  }
+  if _, found := p.m[key]; !found {
+    p.keys = append(p.keys, key)
+  }
+  p.m[key] = value
+}
+
+func (p *OrderedSoyMapData) Delete(key string) {
+  if _, found := p.m[key]; !found {
+    return
+  }
+  delete(p.m, key)
+  for i, k := range p.keys {
+    if k == key {
+      p.keys = append(p.keys[:i], p.keys[i+1:]...)
+      break
+    }
+  }
+}
+
+/**
+ * Calls fn for each entry in insertion order, stopping early if fn returns false.
+ */
+func (p *OrderedSoyMapData) ForEach(fn func(key string, value SoyData) bool) {
+  for _, k := range p.keys {
+    if !fn(k, p.m[k]) {
+      return
+    }
+  }
+}
+
+/**
+ * Returns the entries as a plain SoyMapData, e.g. to pass to GetData.  The map is shared, not
+ * copied, and should not be modified directly or the key order will no longer match it.
+ */
+func (p *OrderedSoyMapData) SoyMapData() SoyMapData {
+  return p.m
+}
+
+func (p *OrderedSoyMapData) Bool() bool {
+  return len(p.keys) > 0
+}
+
+func (p *OrderedSoyMapData) String() string {
+  buf := bytes.NewBufferString("{")
+  for i, k := range p.keys {
+    if i > 0 {
+      buf.WriteString(", ")
+    }
+    buf.WriteString(strconv.Quote(k))
+    buf.WriteString(": ")
+    buf.WriteString(p.m[k].String())
+  }
+  buf.WriteByte('}')
+  return buf.String()
+}
+
+/**
+ * Compares entries like SoyMapData.Equals; key order is not significant, and an
+ * OrderedSoyMapData may equal a SoyMapData with the same entries.
+ */
+func (p *OrderedSoyMapData) Equals(other interface{}) bool {
+  switch o := other.(type) {
+  case *OrderedSoyMapData:
+    if o == nil {
+      return false
+    }
+    return p.m.Equals(o.m)
+  case SoyMapData:
+    return p.m.Equals(o)
+  }
+  return false
+}
+
+/**
+ * Encodes the map as a JSON object with keys in insertion order.
+ */
+func (p *OrderedSoyMapData) MarshalJSON() ([]byte, error) {
+  buf := bytes.NewBufferString("{")
+  for i, key := range p.keys {
+    if i > 0 {
+      buf.WriteByte(',')
+    }
+    k, err := json.Marshal(key)
+    if err != nil {
+      return nil, err
+    }
+    buf.Write(k)
+    buf.WriteByte(':')
+    v, err := MarshalSoyData(p.m[key])
+    if err != nil {
+      return nil, err
+    }
+    buf.Write(v)
+  }
+  buf.WriteByte('}')
+  return buf.Bytes(), nil
+}
+
+func (p *OrderedSoyMapData) SoyData() SoyData {
+  return p
+}
+
+func (p *OrderedSoyMapData) HasElements() bool {
+  return len(p.keys) > 0
+}
+
+func (p *OrderedSoyMapData) IsEmpty() bool {
+  return len(p.keys) == 0
+}
+
+/**
+ * Encodes a SoyData tree as JSON: null, booleans, numbers and strings map to their JSON
+ * counterparts, lists to arrays, maps to objects with sorted keys, and sanitized content to
+ * its content string.  A nil d is encoded as null.
+ */
+func MarshalSoyData(d SoyData) ([]byte, error) {
+  if d == nil {
+    return []byte("null"), nil
+  }
+  return json.Marshal(d)
+}
+
+/**
+ * Returns a copy of d that shares no lists or maps with it, so the copy can be modified without
+ * affecting the original.  Lists keep their order.  Scalar values are immutable and are returned
+ * as is; a nil d yields NilDataInstance.
+ */
+func DeepCopy(d SoyData) SoyData {
+  switch v := d.(type) {
+  case nil:
+    return NilDataInstance
+  case NilData, *NilData, UndefinedData, *UndefinedData:
+    // NilData also implements SoyListData but is a value, not a list.
+    return d
+  case SoyListData:
+    l := NewSoyListData()
+    for e := v.Front(); e != nil; e = e.Next() {
+      l.PushBack(DeepCopy(e.Value.(SoyData)))
+    }
+    return l
+  case SoyMapData:
+    if v == nil {
+      return NilDataInstance
+    }
+    m := make(SoyMapData, len(v))
+    for k, value := range v {
+      m[k] = DeepCopy(value)
+    }
+    return m
+  case *OrderedSoyMapData:
+    if v == nil {
+      return NilDataInstance
+    }
+    m := NewOrderedSoyMapData()
+    v.ForEach(func(k string, value SoyData) bool {
+      m.Set(k, DeepCopy(value))
+      return true
+    })
+    return m
+  }
+  return d
+}
+
+/**
+ * Decodes JSON into a SoyData tree: objects become SoyMapData, arrays SoyListData, integral
+ * numbers IntegerData and other numbers Float64Data, strings StringData, booleans BooleanData,
+ * and null NilDataInstance.  Numbers are decoded from their text so large integers keep their
+ * precision.
+ */
+func UnmarshalSoyData(data []byte) (SoyData, error) {
+  dec := json.NewDecoder(bytes.NewReader(data))
+  dec.UseNumber()
+  var value interface{}
+  if err := dec.Decode(&value); err != nil {
+    return NilDataInstance, err
+  }
+  if _, err := dec.Token(); err != io.EOF {
+    return NilDataInstance, NewSoyDataException("Unexpected data after the JSON value")
+  }
+  d, err := jsonValueToSoyData(value)
+  if err != nil {
+    return NilDataInstance, err
+  }
+  return d, nil
+}
+
+func jsonValueToSoyData(value interface{}) (SoyData, error) {
+  switch v := value.(type) {
+  case nil:
+    return NilDataInstance, nil
+  case bool:
+    return NewBooleanData(v), nil
+  case string:
+    return NewStringData(v), nil
+  case json.Number:
+    if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+      if int64(int(i)) == i {
+        return NewIntegerData(int(i)), nil
+      }
+      return NewInt64Data(i), nil
+    }
+    f, err := v.Float64()
+    if err != nil {
+      return nil, err
+    }
+    return NewFloat64Data(f), nil
+  case []interface{}:
+    l := NewSoyListData()
+    for _, e := range v {
+      sv, err := jsonValueToSoyData(e)
+      if err != nil {
+        return nil, err
+      }
+      l.PushBack(sv)
+    }
+    return l, nil
+  case map[string]interface{}:
+    m := make(SoyMapData, len(v))
+    for k, e := range v {
+      sv, err := jsonValueToSoyData(e)
+      if err != nil {
+        return nil, err
+      }
+      m[k] = sv
+    }
+    return m, nil
+  }
+  return nil, NewSoyDataException(fmt.Sprintf("Unexpected JSON value of type %T", value))
+}
+
+/**
+ * Compares two SoyData trees and reports where they first differ, for test failure messages
+ * that point at e.g. "items.2.price" rather than dumping both trees.  Map keys are visited in
+ * sorted order and list elements by index.
+ * @return The dotted path of the first difference, empty for the roots, and whether the trees
+ *     differ at all.
+ */
+func Diff(a, b SoyData) (path string, differs bool) {
+  if a == nil {
+    a = NilDataInstance
+  }
+  if b == nil {
+    b = NilDataInstance
+  }
+  // NilData also implements SoyListData, so nulls have to be told apart before the list case.
+  if aNull, bNull := isNullData(a), isNullData(b); aNull || bNull {
+    return "", aNull != bNull
+  }
+  switch av := a.(type) {
+  case SoyMap:
+    bv, ok := b.(SoyMap)
+    if !ok {
+      return "", true
+    }
+    keys := av.Keys()
+    for _, k := range bv.Keys() {
+      if !av.Contains(k) {
+        keys = append(keys, k)
+      }
+    }
+    sort.Strings(keys)
+    for _, k := range keys {
+      if !av.Contains(k) || !bv.Contains(k) {
+        return k, true
+      }
+      if subpath, subdiffers := Diff(av.Get(k), bv.Get(k)); subdiffers {
+        return joinDiffPath(k, subpath), true
+      }
+    }
+    return "", false
+  case SoyListData:
+    bv, ok := b.(SoyListData)
+    if !ok {
+      return "", true
+    }
+    i := 0
+    ea, eb := av.Front(), bv.Front()
+    for ; ea != nil && eb != nil; ea, eb = ea.Next(), eb.Next() {
+      if subpath, subdiffers := Diff(ea.Value.(SoyData), eb.Value.(SoyData)); subdiffers {
+        return joinDiffPath(strconv.Itoa(i), subpath), true
+      }
+      i++
+    }
+    if ea != nil || eb != nil {
+      return strconv.Itoa(i), true
+    }
+    return "", false
+  }
+  switch b.(type) {
+  case SoyMap, SoyListData:
+    return "", true
+  }
+  return "", !a.Equals(b)
+}
+
+func joinDiffPath(key, subpath string) string {
+  if len(subpath) == 0 {
+    return key
+  }
+  return key + "." + subpath
+}
+
+/**
+ * A set of SoyData values where two values are the same member when they have the same
+ * HashCode() and are Soy '==' (Equals).  Equals is loose across types, so values of different
+ * kinds that compare equal, such as StringData("1") and IntegerData(1), usually hash differently
+ * and are both kept.  Iteration follows insertion order.
+ */
+type SoyDataSet struct {
+  buckets map[int][]SoyData
+  values []SoyData
+}
+
+func NewSoyDataSet() *SoyDataSet {
+  return &SoyDataSet{buckets: make(map[int][]SoyData), values: make([]SoyData, 0)}
+}
+
+func soyDataHashCode(v SoyData) int {
+  if h, ok := v.(HashCoder); ok {
+    return h.HashCode()
+  }
+  return 0
+}
+
+/**
+ * Adds v unless an equal value is already present.
+ * @return Whether v was added.
+ */
+func (p *SoyDataSet) Add(v SoyData) bool {
+  if v == nil {
+    v = NilDataInstance
+  }
+  h := soyDataHashCode(v)
+  for _, o := range p.buckets[h] {
+    if o.Equals(v) {
+      return false
+    }
+  }
+  p.buckets[h] = append(p.buckets[h], v)
+  p.values = append(p.values, v)
+  return true
+}
+
+func (p *SoyDataSet) Contains(v SoyData) bool {
+  if v == nil {
+    v = NilDataInstance
+  }
+  for _, o := range p.buckets[soyDataHashCode(v)] {
+    if o.Equals(v) {
+      return true
+    }
+  }
+  return false
+}
+
+func (p *SoyDataSet) Len() int {
+  return len(p.values)
+}
+
+/**
+ * Returns the values in the order they were first added.
+ */
+func (p *SoyDataSet) ToList() SoyListData {
+  return NewSoyListDataFromVector(p.values)
+}
+
+const (
+  _INTERNED_INTEGER_MIN = -1
+  _INTERNED_INTEGER_MAX = 256
+)
+
+/**
+ * Boxed SoyData values for the booleans and the most common small integers, so converting
+ * them with ToSoyData does not allocate a new interface value each time.  Go already boxes
+ * booleans and 0..255 without allocating; the table also covers -1 and 256, which it does not.
+ */
+var (
+  _TRUE_DATA SoyData = NewBooleanData(true)
+  _FALSE_DATA SoyData = NewBooleanData(false)
+  _INTERNED_INTEGER_DATA = func() []SoyData {
+    values := make([]SoyData, _INTERNED_INTEGER_MAX - _INTERNED_INTEGER_MIN + 1)
+    for i := range values {
+      values[i] = NewIntegerData(i + _INTERNED_INTEGER_MIN)
+    }
+    return values
+  }()
+)
+
+func booleanSoyData(value bool) SoyData {
+  if value {
+    return _TRUE_DATA
+  }
+  return _FALSE_DATA
+}
+
+func integerSoyData(value int) SoyData {
+  if value >= _INTERNED_INTEGER_MIN && value <= _INTERNED_INTEGER_MAX {
+    return _INTERNED_INTEGER_DATA[value - _INTERNED_INTEGER_MIN]
+  }
+  return NewIntegerData(value)
+}
+
 func ToBooleanData(obj interface{}) BooleanData {
-  if obj == nil || obj == NilDataInstance {
+  if isNullData(obj) {
     return NewBooleanData(false)
   }
   if o, ok := obj.(BooleanData); ok {
@@ -932,7 +1892,7 @@ func ToBooleanData(obj interface{}) BooleanData {
 }
 
 func ToIntegerData(obj interface{}) IntegerData {
-  if obj == nil || obj == NilDataInstance {
+  if isNullData(obj) {
     return NewIntegerData(0)
   }
   if o, ok := obj.(IntegerData); ok {
@@ -946,7 +1906,7 @@ func ToIntegerData(obj interface{}) IntegerData {
 }
 
 func ToFloat64Data(obj interface{}) Float64Data {
-  if obj == nil || obj == NilDataInstance {
+  if isNullData(obj) {
     return NewFloat64Data(0.0)
   }
   if o, ok := obj.(Float64Data); ok {
@@ -960,7 +1920,7 @@ func ToFloat64Data(obj interface{}) Float64Data {
 }
 
 func ToStringData(obj interface{}) StringData {
-  if obj == nil || obj == NilDataInstance {
+  if isNullData(obj) {
     return NewStringData("")
   }
   if o, ok := obj.(StringData); ok {
@@ -974,7 +1934,7 @@ func ToStringData(obj interface{}) StringData {
 }
 
 func ToSoyListData(obj interface{}) SoyListData {
-  if obj == nil || obj == NilDataInstance {
+  if isNullData(obj) {
     return NewSoyListData()
   }
   if o, ok := obj.(SoyListData); ok {
@@ -988,7 +1948,7 @@ func ToSoyListData(obj interface{}) SoyListData {
 }
 
 func ToSoyMapData(obj interface{}) SoyMapData {
-  if obj == nil || obj == NilDataInstance {
+  if isNullData(obj) {
     return NewSoyMapData()
   }
   if o, ok := obj.(SoyMapData); ok {
@@ -1006,6 +1966,31 @@ func ToSoyDataNoErr(obj interface{}) SoyData {
   return s
 }
 
+/**
+ * Returns the map key for a struct field: the name from a soy:"..." tag, else from a
+ * json:"..." tag, else the field name.  Options after a comma, as in json:"name,omitempty",
+ * are ignored.
+ * @return The key, and false if the field is tagged to be skipped with "-".
+ */
+func soyFieldName(f reflect.StructField) (string, bool) {
+  for _, tagName := range []string{"soy", "json"} {
+    tag, ok := f.Tag.Lookup(tagName)
+    if !ok {
+      continue
+    }
+    if tag == "-" {
+      return "", false
+    }
+    if comma := strings.Index(tag, ","); comma >= 0 {
+      tag = tag[0:comma]
+    }
+    if len(tag) > 0 {
+      return tag, true
+    }
+  }
+  return f.Name, true
+}
+
 /**
  * Creation function for creating a SoyData object out of any existing primitive, data object, or
  * data structure.
@@ -1032,6 +2017,68 @@ func ToSoyDataNoErr(obj interface{}) SoyData {
  * @throws SoyDataException If the given object cannot be converted to SoyData.
  */
 func ToSoyData(obj interface{}) (SoyData, error) {
+  return toSoyData(obj, &soyDataConversion{})
+}
+
+/**
+ * Like ToSoyData but fails once more than maxNodes values have been converted, to protect
+ * against huge or adversarial inputs.  Every value counts as a node, including the lists and
+ * maps themselves, but map keys do not.
+ * @return A SoyDataException if the limit is exceeded.
+ */
+func ToSoyDataLimited(obj interface{}, maxNodes int) (SoyData, error) {
+  if maxNodes < 0 {
+    maxNodes = 0
+  }
+  state := &soyDataConversion{maxNodes: maxNodes, limited: true}
+  d, err := toSoyData(obj, state)
+  if state.err != nil {
+    return NilDataInstance, state.err
+  }
+  return d, err
+}
+
+/**
+ * Identifies a map, slice or pointer that is being converted.  The type is included since a
+ * struct and its first field share an address.
+ */
+type soyDataVisit struct {
+  ptr uintptr
+  typ reflect.Type
+}
+
+/**
+ * The state of one ToSoyData call.
+ */
+type soyDataConversion struct {
+  /**
+   * The maps, slices and pointers currently being converted further up the tree, so that a
+   * value referring back to one of them is converted to NilDataInstance instead of recursing
+   * forever.  It is allocated on first use.
+   */
+  visited map[soyDataVisit]bool
+  /** The number of values converted so far. */
+  nodes int
+  /** Whether maxNodes applies. */
+  limited bool
+  /** The most values ToSoyDataLimited may convert before it fails. */
+  maxNodes int
+  /** Set once the node limit is exceeded, which stops the conversion. */
+  err error
+}
+
+/**
+ * Implements ToSoyData.
+ */
+func toSoyData(obj interface{}, state *soyDataConversion) (SoyData, error) {
+  if state.err != nil {
+    return NilDataInstance, state.err
+  }
+  state.nodes++
+  if state.limited && state.nodes > state.maxNodes {
+    state.err = NewSoyDataException(fmt.Sprintf("Cannot convert more than %d values to Soy data.", state.maxNodes))
+    return NilDataInstance, state.err
+  }
   if obj == nil {
     return NilDataInstance, nil
   }
@@ -1046,15 +2093,21 @@ func ToSoyData(obj interface{}) (SoyData, error) {
   case string:
     return NewStringData(o), nil
   case bool:
-    return NewBooleanData(o), nil
+    return booleanSoyData(o), nil
   case uint:
-    return NewIntegerData(int(o)), nil
+    return integerSoyData(int(o)), nil
   case int:
-    return NewIntegerData(o), nil
+    return integerSoyData(o), nil
   case int32:
-    return NewIntegerData(int(o)), nil
+    return integerSoyData(int(o)), nil
   case int64:
-    return NewIntegerData(int(o)), nil
+    return NewInt64Data(o), nil
+  case uint64:
+    if o > math.MaxInt64 {
+      str := fmt.Sprintf("Cannot convert %d to Soy data: it does not fit in an int64.", o)
+      return NilDataInstance, NewSoyDataException(str)
+    }
+    return NewInt64Data(int64(o)), nil
   case float32:
     return NewFloat64Data(float64(o)), nil
   case float64:
@@ -1063,9 +2116,25 @@ func ToSoyData(obj interface{}) (SoyData, error) {
     return NewSoyListDataFromList(o), nil
   case []SoyData:
     return NewSoyListDataFromVector(o), nil
+  case error:
+    return NewStringData(o.Error()), nil
   }
   rv := reflect.ValueOf(obj)
   switch rv.Kind() {
+  case reflect.Map, reflect.Slice, reflect.Ptr:
+    if !rv.IsNil() && (rv.Kind() != reflect.Slice || rv.Len() > 0) {
+      visit := soyDataVisit{rv.Pointer(), rv.Type()}
+      if state.visited[visit] {
+        return NilDataInstance, nil
+      }
+      if state.visited == nil {
+        state.visited = make(map[soyDataVisit]bool)
+      }
+      state.visited[visit] = true
+      defer delete(state.visited, visit)
+    }
+  }
+  switch rv.Kind() {
   case reflect.Array, reflect.Slice:
     l := NewSoyListData()
     for i := 0; i < rv.Len(); i++ {
@@ -1074,7 +2143,10 @@ func ToSoyData(obj interface{}) (SoyData, error) {
       if v.Interface() == nil {
         sv = NilDataInstance
       } else {
-        sv, _ = ToSoyData(v.Interface())
+        sv, _ = toSoyData(v.Interface(), state)
+      }
+      if state.err != nil {
+        return NilDataInstance, state.err
       }
       l.PushBack(sv)
     }
@@ -1091,14 +2163,18 @@ func ToSoyData(obj interface{}) (SoyData, error) {
           k = st.String()
         } else if k, ok = key.Interface().(string); ok {
         } else {
-          s, _ := ToSoyData(key.Interface())
+          // Keys are not values of the result, so they do not count towards the node limit.
+          s, _ := toSoyData(key.Interface(), &soyDataConversion{})
           k = s.StringValue()
         }
         av := rv.MapIndex(key)
         if av.Interface() == nil {
           sv = NilDataInstance
         } else {
-          sv, _ = ToSoyData(av.Interface())
+          sv, _ = toSoyData(av.Interface(), state)
+        }
+        if state.err != nil {
+          return NilDataInstance, state.err
         }
         m.Set(k, sv)
       }
@@ -1109,13 +2185,31 @@ func ToSoyData(obj interface{}) (SoyData, error) {
     rt := rv.Type()
     for i := 0; i < rt.NumField(); i++ {
       f := rt.Field(i)
-      k := f.Name
-      v, _ := ToSoyData(rv.Field(i).Interface())
+      if f.PkgPath != "" {
+        // Unexported fields cannot be read through reflection.
+        continue
+      }
+      k, ok := soyFieldName(f)
+      if !ok {
+        continue
+      }
+      v, _ := toSoyData(rv.Field(i).Interface(), state)
+      if state.err != nil {
+        return NilDataInstance, state.err
+      }
       m.Set(k, v)
     }
     return m, nil
+  case reflect.Ptr:
+    if rv.IsNil() {
+      return NilDataInstance, nil
+    }
+    return toSoyData(rv.Elem().Interface(), state)
+  case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+    str := fmt.Sprintf("Cannot convert a %s to Soy data (object type %T).", rv.Kind(), obj)
+    return NilDataInstance, NewSoyDataException(str)
   }
-  str := fmt.Sprintf("Attempting to convert unrecognized object to Soy data (object type %t).", obj)
+  str := fmt.Sprintf("Attempting to convert unrecognized object to Soy data (object type %T).", obj)
   return NilDataInstance, NewSoyDataException(str)
 }
 
@@ -2,15 +2,19 @@ package soyutil_test;
 
 import (
   . "closure/template/soyutil"
+  "encoding/json"
+  "fmt"
+  "math"
+  "strings"
   "testing"
 )
 
 func assertBoolEquals(t *testing.T, expected, actual bool, errormsg string) {
   if expected != actual {
     if len(errormsg) > 0 {
-      t.Errorf("%s\nExpected: %s but was: %d %v", errormsg, expected, actual, expected == actual)
+      t.Errorf("%s\nExpected: %v but was: %v %v", errormsg, expected, actual, expected == actual)
     } else {
-      t.Errorf("Expected: %s but was: %d %v", expected, actual, expected == actual)
+      t.Errorf("Expected: %v but was: %v %v", expected, actual, expected == actual)
     }
   }
 }
@@ -38,9 +42,9 @@ func assertFloat64Equals(t *testing.T, expected, actual float64, errormsg string
 func assertStringEquals(t *testing.T, expected, actual, errormsg string) {
   if expected != actual {
     if len(errormsg) > 0 {
-      t.Errorf("%s\nExpected: \"%s\"\n but was: \"%s\", %d %d %s", errormsg, expected, actual, len(expected), len(actual), expected == actual)
+      t.Errorf("%s\nExpected: \"%s\"\n but was: \"%s\", %d %d %v", errormsg, expected, actual, len(expected), len(actual), expected == actual)
     } else {
-      t.Errorf("Expected: \"%s\"\n but was: \"%s\" %d %d %s", expected, actual, len(expected), len(actual), expected == actual)
+      t.Errorf("Expected: \"%s\"\n but was: \"%s\" %d %d %v", expected, actual, len(expected), len(actual), expected == actual)
     }
   }
 }
@@ -48,9 +52,9 @@ func assertStringEquals(t *testing.T, expected, actual, errormsg string) {
 func assertSoyDataEquals(t *testing.T, expected, actual SoyData, errormsg string) {
   if expected != actual {
     if len(errormsg) > 0 {
-      t.Errorf("%s\nExpected: %v\n but was: %v, %s", errormsg, expected, actual, expected.Equals(actual))
+      t.Errorf("%s\nExpected: %v\n but was: %v, %v", errormsg, expected, actual, expected.Equals(actual))
     } else {
-      t.Errorf("Expected: %v\n but was: %v, %s", expected, actual, expected.Equals(actual))
+      t.Errorf("Expected: %v\n but was: %v, %v", expected, actual, expected.Equals(actual))
     }
   }
 }
@@ -104,3 +108,744 @@ func TestToSoyData(t *testing.T) {
   
 }
 
+
+func TestBooleanDataEquals(t *testing.T) {
+  assertBoolEquals(t, true, NewBooleanData(false).Equals(NewIntegerData(0)), "false == 0")
+  assertBoolEquals(t, true, NewBooleanData(false).Equals(NewStringData("")), "false == \"\"")
+  assertBoolEquals(t, true, NewBooleanData(true).Equals(NewStringData("x")), "true == \"x\"")
+  assertBoolEquals(t, true, NewBooleanData(true).Equals(NewIntegerData(2)), "true == 2")
+  assertBoolEquals(t, false, NewBooleanData(true).Equals(NewIntegerData(0)), "true == 0")
+}
+
+func TestStrictBoolEquals(t *testing.T) {
+  assertBoolEquals(t, false, StrictBoolEquals(NewBooleanData(false), NewIntegerData(0)), "strict false == 0")
+  assertBoolEquals(t, false, StrictBoolEquals(NewBooleanData(true), NewStringData("x")), "strict true == \"x\"")
+  assertBoolEquals(t, true, StrictBoolEquals(NewBooleanData(true), NewBooleanData(true)), "strict true == true")
+  assertBoolEquals(t, true, StrictBoolEquals(NewBooleanData(false), false), "strict false == false")
+  assertBoolEquals(t, false, StrictBoolEquals(NewBooleanData(false), NewBooleanData(true)), "strict false == true")
+}
+
+func TestToSoyDataRejectsChanAndFunc(t *testing.T) {
+  _, err := ToSoyData(make(chan int))
+  if err == nil || !strings.Contains(err.Error(), "chan") {
+    t.Errorf("ToSoyData(chan) should fail naming the chan kind, but got: %v", err)
+  }
+  _, err = ToSoyData(func() {})
+  if err == nil || !strings.Contains(err.Error(), "func") {
+    t.Errorf("ToSoyData(func) should fail naming the func kind, but got: %v", err)
+  }
+  if _, ok := err.(*SoyDataException); !ok {
+    t.Errorf("ToSoyData(func) should return a *SoyDataException, but got: %T", err)
+  }
+}
+
+func TestToSoyDataUnsupportedTypeError(t *testing.T) {
+  _, err := ToSoyData(complex(1, 2))
+  if err == nil || !strings.Contains(err.Error(), "complex128") {
+    t.Errorf("ToSoyData(complex128) should fail naming the type, but got: %v", err)
+  }
+}
+
+func TestSoyMapDataEqualsIdentity(t *testing.T) {
+  m := NewSoyMapDataFromArgs("a", 1, "b", "two")
+  assertBoolEquals(t, true, m.Equals(m), "map compared to itself")
+  assertBoolEquals(t, true, m.Equals(NewSoyMapDataFromArgs("a", 1, "b", "two")), "map compared to an equal map")
+  assertBoolEquals(t, false, m.Equals(NewSoyMapDataFromArgs("a", 1)), "map compared to a smaller map")
+  assertBoolEquals(t, false, m.Equals(NewSoyMapDataFromArgs("a", 1, "b", "three")), "map compared to a map with a different value")
+  assertBoolEquals(t, false, m.Equals(NewSoyMapDataFromArgs("a", 1, "c", "two")), "map compared to a map with a different key")
+}
+
+func TestSoyMapDataEqualsNested(t *testing.T) {
+  m := NewSoyMapDataFromArgs("outer", NewSoyMapDataFromArgs("inner", 1, "other", "x"))
+  assertBoolEquals(t, true, m.Equals(NewSoyMapDataFromArgs("outer", NewSoyMapDataFromArgs("inner", 1, "other", "x"))), "equal nested maps")
+  assertBoolEquals(t, false, m.Equals(NewSoyMapDataFromArgs("outer", NewSoyMapDataFromArgs("inner", 2, "other", "x"))), "nested maps with a different value")
+  assertBoolEquals(t, false, m.Equals(NewSoyMapDataFromArgs("outer", NewSoyMapDataFromArgs("inner", 1))), "nested map missing a key")
+}
+
+func TestSoyMapDataMarshalJSONSortsKeys(t *testing.T) {
+  m := NewSoyMapDataFromArgs("zeta", 1, "alpha", "a<b", "mid", true, "empty", nil)
+  expected := `{"alpha":"a\u003cb","empty":null,"mid":true,"zeta":1}`
+  for i := 0; i < 10; i++ {
+    b, err := json.Marshal(m)
+    if err != nil {
+      t.Fatalf("json.Marshal(SoyMapData) failed: %v", err)
+    }
+    assertStringEquals(t, expected, string(b), "SoyMapData.MarshalJSON()")
+  }
+}
+
+func TestSoyListDataRemoveValue(t *testing.T) {
+  l := NewSoyListDataFromArgs("a", "b", 3, "b")
+  assertBoolEquals(t, true, l.RemoveValue(NewStringData("b")), "RemoveValue of a present value")
+  assertIntEquals(t, 3, l.Len(), "Len after removing a present value")
+  assertSoyDataEquals(t, NewIntegerData(3), l.At(1), "first match should be removed")
+  assertBoolEquals(t, false, l.RemoveValue(NewStringData("z")), "RemoveValue of an absent value")
+  assertIntEquals(t, 3, l.Len(), "Len after removing an absent value")
+  assertBoolEquals(t, false, NilDataInstance.RemoveValue(NewStringData("a")), "RemoveValue on NilData")
+}
+
+func TestSoyDataSet(t *testing.T) {
+  set := NewSoyDataSet()
+  assertBoolEquals(t, true, set.Add(NewStringData("a")), "Add a new string")
+  assertBoolEquals(t, false, set.Add(NewStringData("a")), "Add a duplicate string")
+  assertBoolEquals(t, true, set.Add(NewStringData("b")), "Add another string")
+  assertBoolEquals(t, true, set.Add(NewIntegerData(1)), "Add an integer")
+  assertBoolEquals(t, false, set.Add(NewFloat64Data(1.0)), "Add an equal float")
+  assertIntEquals(t, 3, set.Len(), "SoyDataSet.Len()")
+  assertBoolEquals(t, true, set.Contains(NewStringData("b")), "Contains a present value")
+  assertBoolEquals(t, false, set.Contains(NewStringData("c")), "Contains an absent value")
+  l := set.ToList()
+  assertIntEquals(t, 3, l.Len(), "SoyDataSet.ToList().Len()")
+  assertSoyDataEquals(t, NewStringData("a"), l.At(0), "SoyDataSet.ToList() order")
+  assertSoyDataEquals(t, NewIntegerData(1), l.At(2), "SoyDataSet.ToList() order")
+}
+
+func TestSoyDataSetCrossType(t *testing.T) {
+  set := NewSoyDataSet()
+  assertBoolEquals(t, true, NewStringData("1").Equals(NewIntegerData(1)), "StringData(\"1\") == IntegerData(1)")
+  assertBoolEquals(t, true, set.Add(NewIntegerData(1)), "Add an integer")
+  assertBoolEquals(t, true, set.Add(NewStringData("1")), "Add an equal string with a different hash")
+  assertBoolEquals(t, false, set.Add(NewStringData("1")), "Add a duplicate string")
+  assertBoolEquals(t, true, set.Contains(NewIntegerData(1)), "Contains the integer")
+  assertIntEquals(t, 2, set.Len(), "SoyDataSet.Len() keeps equal values of different kinds")
+}
+
+func TestListToStrings(t *testing.T) {
+  arr := ListToStrings(NewSoyListDataFromArgs("a", 1, 2.5, true, nil))
+  expected := []string{"a", "1", "2.5", "true", "null"}
+  assertIntEquals(t, len(expected), len(arr), "ListToStrings length")
+  for i := 0; i < len(expected) && i < len(arr); i++ {
+    assertStringEquals(t, expected[i], arr[i], "ListToStrings element")
+  }
+  assertIntEquals(t, 0, len(ListToStrings(nil)), "ListToStrings(nil) length")
+}
+
+func TestSanitizedContentNumberValue(t *testing.T) {
+  five := NewSanitizedContent("5", CONTENT_KIND_HTML)
+  assertIntEquals(t, 5, five.IntegerValue(), "SanitizedContent(\"5\").IntegerValue()")
+  assertFloat64Equals(t, 8, Plus(five, NewIntegerData(3)).NumberValue(), "SanitizedContent(\"5\") + 3")
+  assertFloat64Equals(t, 2, Minus(five, NewIntegerData(3)).NumberValue(), "SanitizedContent(\"5\") - 3")
+  assertFloat64Equals(t, 2.5, NewSanitizedContent("2.5", CONTENT_KIND_HTML).NumberValue(), "SanitizedContent(\"2.5\").NumberValue()")
+  assertIntEquals(t, 2, NewSanitizedContent("2.5", CONTENT_KIND_HTML).IntegerValue(), "SanitizedContent(\"2.5\").IntegerValue()")
+  assertFloat64Equals(t, 0, NewSanitizedContent("<b>5</b>", CONTENT_KIND_HTML).NumberValue(), "non-numeric SanitizedContent")
+}
+
+func TestStringDataNumericCoercion(t *testing.T) {
+  assertIntEquals(t, 42, NewStringData("42").IntegerValue(), "StringData(\"42\").IntegerValue()")
+  assertFloat64Equals(t, 42, NewStringData("42").NumberValue(), "StringData(\"42\").NumberValue()")
+  assertIntEquals(t, 3, NewStringData("3.14").IntegerValue(), "StringData(\"3.14\").IntegerValue()")
+  assertFloat64Equals(t, 3.14, NewStringData("3.14").Float64Value(), "StringData(\"3.14\").Float64Value()")
+  assertIntEquals(t, -7, NewStringData("-7").IntegerValue(), "StringData(\"-7\").IntegerValue()")
+  assertFloat64Equals(t, -7, NewStringData("-7").NumberValue(), "StringData(\"-7\").NumberValue()")
+  assertIntEquals(t, 0, NewStringData("").IntegerValue(), "StringData(\"\").IntegerValue()")
+  assertFloat64Equals(t, 0, NewStringData("").NumberValue(), "StringData(\"\").NumberValue()")
+  assertIntEquals(t, 0, NewStringData("abc").IntegerValue(), "StringData(\"abc\").IntegerValue()")
+  assertFloat64Equals(t, 0, NewStringData("abc").Float64Value(), "StringData(\"abc\").Float64Value()")
+  assertBoolEquals(t, true, NewStringData("abc").BooleanValue(), "StringData(\"abc\").BooleanValue()")
+  assertBoolEquals(t, false, NewStringData("").BooleanValue(), "StringData(\"\").BooleanValue()")
+}
+
+func TestIntegerDataStringValue(t *testing.T) {
+  assertStringEquals(t, "65", NewIntegerData(65).StringValue(), "IntegerData(65).StringValue()")
+  assertStringEquals(t, "128512", NewIntegerData(128512).StringValue(), "IntegerData(128512).StringValue()")
+  assertStringEquals(t, "-3", NewIntegerData(-3).StringValue(), "IntegerData(-3).StringValue()")
+}
+
+func TestSanitizedContentInSoyMapData(t *testing.T) {
+  sc := NewSanitizedContent("<b>bold</b>", CONTENT_KIND_HTML)
+  m := NewSoyMapData()
+  m.Set("content", sc)
+  v, ok := m.Get("content").(*SanitizedContent)
+  if !ok {
+    t.Fatalf("SoyMapData.Get(\"content\") is of type %T, expected *SanitizedContent", m.Get("content"))
+  }
+  assertStringEquals(t, "<b>bold</b>", v.Content(), "SanitizedContent read back from a SoyMapData")
+  assertBoolEquals(t, true, v.ContentKind() == CONTENT_KIND_HTML, "SanitizedContent kind read back from a SoyMapData")
+  assertBoolEquals(t, true, v.SoyData() == SoyData(sc), "SanitizedContent.SoyData() returns the receiver")
+  assertIntEquals(t, 0, v.IntegerValue(), "non-numeric SanitizedContent.IntegerValue()")
+}
+
+func TestDeepCopy(t *testing.T) {
+  inner := NewSoyListDataFromArgs(1, 2)
+  original := NewSoyMapDataFromArgs("list", inner, "map", NewSoyMapDataFromArgs("a", "b"), "s", "x")
+  c, ok := DeepCopy(original).(SoyMapData)
+  if !ok {
+    t.Fatalf("DeepCopy(SoyMapData) is of type %T", DeepCopy(original))
+  }
+  assertBoolEquals(t, true, original.Equals(c), "DeepCopy equals the original")
+  c.Get("list").(SoyListData).PushBack(NewIntegerData(3))
+  c.Get("map").(SoyMapData).Set("a", NewStringData("changed"))
+  c.Set("s", NewStringData("y"))
+  assertIntEquals(t, 2, inner.Len(), "original list after modifying the copy")
+  assertSoyDataEquals(t, NewIntegerData(2), inner.At(1), "original list order")
+  assertStringEquals(t, "b", original.Get("map").(SoyMapData).Get("a").String(), "original nested map after modifying the copy")
+  assertStringEquals(t, "x", original.Get("s").String(), "original map after modifying the copy")
+  l := DeepCopy(NewSoyListDataFromArgs("a", "b", "c")).(SoyListData)
+  assertStringEquals(t, "c", l.At(2).String(), "DeepCopy keeps list order")
+  assertSoyDataEquals(t, NilDataInstance, DeepCopy(nil), "DeepCopy(nil)")
+  assertSoyDataEquals(t, NewIntegerData(7), DeepCopy(NewIntegerData(7)), "DeepCopy of a scalar")
+}
+
+func TestDeepCopyNull(t *testing.T) {
+  if c := DeepCopy(NilDataInstance); c != NilDataInstance {
+    t.Errorf("DeepCopy(NilDataInstance) is %T %v, expected NilDataInstance", c, c)
+  }
+  if c := DeepCopy(UndefinedDataInstance); c != UndefinedDataInstance {
+    t.Errorf("DeepCopy(UndefinedDataInstance) is %T %v, expected UndefinedDataInstance", c, c)
+  }
+  c := DeepCopy(NewSoyMapDataFromArgs("a", NilDataInstance, "b", NewSoyListData())).(SoyMapData)
+  if _, ok := c.Get("a").(*NilData); !ok {
+    t.Errorf("DeepCopy of a null map value is %T %v, expected NilData", c.Get("a"), c.Get("a"))
+  }
+  if _, ok := c.Get("b").(SoyListData); !ok {
+    t.Errorf("DeepCopy of an empty list map value is %T, expected SoyListData", c.Get("b"))
+  }
+}
+
+func TestToSoyDataSmallValues(t *testing.T) {
+  for i := -2; i <= 300; i++ {
+    assertSoyDataEquals(t, NewIntegerData(i), ToSoyDataNoErr(i), "ToSoyData of a small int")
+    assertIntEquals(t, i, ToSoyDataNoErr(int64(i)).IntegerValue(), "ToSoyData of a small int64")
+  }
+  assertSoyDataEquals(t, NewBooleanData(true), ToSoyDataNoErr(true), "ToSoyData(true)")
+  assertSoyDataEquals(t, NewBooleanData(false), ToSoyDataNoErr(false), "ToSoyData(false)")
+  if _, ok := ToSoyDataNoErr(1).(IntegerData); !ok {
+    t.Errorf("ToSoyData(1) is of type %T, expected IntegerData", ToSoyDataNoErr(1))
+  }
+}
+
+func BenchmarkToSoyDataInternedInts(b *testing.B) {
+  // -1 and 256 fall outside the values Go boxes without allocating, so only the interned
+  // table keeps these conversions allocation free.
+  values := []interface{}{-1, 256}
+  b.ReportAllocs()
+  for i := 0; i < b.N; i++ {
+    ToSoyData(values[i & 1])
+  }
+}
+
+func TestMarshalSoyData(t *testing.T) {
+  d := NewSoyMapDataFromArgs(
+      "name", "Albert",
+      "born", 1879,
+      "height", 1.75,
+      "alive", false,
+      "spouse", nil,
+      "bio", NewSanitizedContent("<i>physicist", CONTENT_KIND_HTML),
+      "papers", NewSoyListDataFromArgs("relativity", 1905, NewSoyMapDataFromArgs("z", 1, "a", NewSoyListData())))
+  expected := `{"alive":false,"bio":"\u003ci\u003ephysicist","born":1879,"height":1.75,"name":"Albert","papers":["relativity",1905,{"a":[],"z":1}],"spouse":null}`
+  b, err := MarshalSoyData(d)
+  if err != nil {
+    t.Fatalf("MarshalSoyData failed: %v", err)
+  }
+  assertStringEquals(t, expected, string(b), "MarshalSoyData(nested)")
+  var decoded interface{}
+  if err := json.Unmarshal(b, &decoded); err != nil {
+    t.Fatalf("json.Unmarshal of MarshalSoyData output failed: %v", err)
+  }
+  roundTrip, err := json.Marshal(ToSoyDataNoErr(decoded))
+  if err != nil {
+    t.Fatalf("json.Marshal of a round-tripped tree failed: %v", err)
+  }
+  assertStringEquals(t, expected, string(roundTrip), "MarshalSoyData after a round trip")
+  b, _ = MarshalSoyData(nil)
+  assertStringEquals(t, "null", string(b), "MarshalSoyData(nil)")
+  b, _ = MarshalSoyData(NilDataInstance)
+  assertStringEquals(t, "null", string(b), "MarshalSoyData(NilDataInstance)")
+}
+
+func TestDiff(t *testing.T) {
+  a := NewSoyMapDataFromArgs("items", NewSoyListDataFromArgs(
+      NewSoyMapDataFromArgs("price", 1), NewSoyMapDataFromArgs("price", 2), NewSoyMapDataFromArgs("price", 3, "name", "c")))
+  b := NewSoyMapDataFromArgs("items", NewSoyListDataFromArgs(
+      NewSoyMapDataFromArgs("price", 1), NewSoyMapDataFromArgs("price", 2), NewSoyMapDataFromArgs("price", 4, "name", "c")))
+  path, differs := Diff(a, b)
+  assertBoolEquals(t, true, differs, "Diff of maps with a different nested value")
+  assertStringEquals(t, "items.2.price", path, "Diff path of a different nested value")
+
+  path, differs = Diff(a, DeepCopy(a))
+  assertBoolEquals(t, false, differs, "Diff of equal trees")
+  assertStringEquals(t, "", path, "Diff path of equal trees")
+
+  path, differs = Diff(NewSoyMapDataFromArgs("a", 1), NewSoyMapDataFromArgs("a", 1, "b", 2))
+  assertBoolEquals(t, true, differs, "Diff with a missing key")
+  assertStringEquals(t, "b", path, "Diff path of a missing key")
+
+  path, differs = Diff(NewSoyListDataFromArgs(1, 2), NewSoyListDataFromArgs(1, 2, 3))
+  assertBoolEquals(t, true, differs, "Diff of lists with different lengths")
+  assertStringEquals(t, "2", path, "Diff path of an extra list element")
+
+  path, differs = Diff(NewIntegerData(1), NewStringData("x"))
+  assertBoolEquals(t, true, differs, "Diff of different scalars")
+  assertStringEquals(t, "", path, "Diff path of different scalars")
+
+  path, differs = Diff(NilDataInstance, NewSoyListData())
+  assertBoolEquals(t, true, differs, "Diff of null and an empty list")
+  path, differs = Diff(NewSoyListData(), NilDataInstance)
+  assertBoolEquals(t, true, differs, "Diff of an empty list and null")
+  path, differs = Diff(NewSoyMapDataFromArgs("a", NilDataInstance), NewSoyMapDataFromArgs("a", NewSoyListData()))
+  assertBoolEquals(t, true, differs, "Diff of a null and an empty list map value")
+  assertStringEquals(t, "a", path, "Diff path of a null and an empty list map value")
+  path, differs = Diff(NilDataInstance, UndefinedDataInstance)
+  assertBoolEquals(t, false, differs, "Diff of null and undefined")
+
+  ordered := NewOrderedSoyMapDataFromPairs("b", 1, "a", 2)
+  path, differs = Diff(ordered, NewSoyMapDataFromArgs("a", 2, "b", 1))
+  assertBoolEquals(t, false, differs, "Diff of an ordered map and an equal map")
+  path, differs = Diff(ordered, NewSoyMapDataFromArgs("a", 2, "b", 3))
+  assertBoolEquals(t, true, differs, "Diff of an ordered map and a different map")
+  assertStringEquals(t, "b", path, "Diff path of an ordered map and a different map")
+}
+
+func TestUnmarshalSoyData(t *testing.T) {
+  d, err := UnmarshalSoyData([]byte(`{"name":"Albert","born":1879,"height":1.75,"alive":false,"spouse":null,
+      "papers":["relativity",1905,2.5,true,null,{"pages":30}],"address":{"city":"Bern","zip":3000}}`))
+  if err != nil {
+    t.Fatalf("UnmarshalSoyData failed: %v", err)
+  }
+  m, ok := d.(SoyMapData)
+  if !ok {
+    t.Fatalf("UnmarshalSoyData of an object is of type %T", d)
+  }
+  assertSoyDataEquals(t, NewStringData("Albert"), m.Get("name"), "string value")
+  if _, ok := m.Get("born").(IntegerData); !ok {
+    t.Errorf("integral number is of type %T, expected IntegerData", m.Get("born"))
+  }
+  assertIntEquals(t, 1879, m.Get("born").IntegerValue(), "integral number value")
+  if _, ok := m.Get("height").(Float64Data); !ok {
+    t.Errorf("fractional number is of type %T, expected Float64Data", m.Get("height"))
+  }
+  assertFloat64Equals(t, 1.75, m.Get("height").Float64Value(), "fractional number value")
+  assertSoyDataEquals(t, NewBooleanData(false), m.Get("alive"), "boolean value")
+  assertBoolEquals(t, true, m.Contains("spouse"), "null value is stored")
+  assertSoyDataEquals(t, NilDataInstance, m.Get("spouse"), "null value")
+  assertBoolEquals(t, false, IsUndefined(m.Get("spouse")), "null value is not undefined")
+  _, differs := Diff(m.Get("address"), NewSoyMapDataFromArgs("city", "Bern", "zip", 3000))
+  assertBoolEquals(t, false, differs, "nested object")
+  papers, ok := m.Get("papers").(SoyListData)
+  if !ok {
+    t.Fatalf("UnmarshalSoyData of an array is of type %T", m.Get("papers"))
+  }
+  _, differs = Diff(papers, NewSoyListDataFromArgs("relativity", 1905, 2.5, true, nil, NewSoyMapDataFromArgs("pages", 30)))
+  assertBoolEquals(t, false, differs, "mixed-type array")
+  if _, ok := papers.At(2).(Float64Data); !ok {
+    t.Errorf("2.5 in an array is of type %T, expected Float64Data", papers.At(2))
+  }
+
+  big, err := UnmarshalSoyData([]byte("9007199254740993"))
+  if err != nil {
+    t.Fatalf("UnmarshalSoyData of a large integer failed: %v", err)
+  }
+  assertStringEquals(t, "9007199254740993", big.String(), "large integer keeps its precision")
+
+  if d, err := UnmarshalSoyData([]byte(`{"a":`)); err == nil {
+    t.Errorf("UnmarshalSoyData of truncated JSON should fail")
+  } else if d != NilDataInstance {
+    t.Errorf("UnmarshalSoyData of truncated JSON returned %T %v, expected NilDataInstance", d, d)
+  }
+  if d, err := UnmarshalSoyData([]byte(`1 2`)); err == nil {
+    t.Errorf("UnmarshalSoyData with trailing data should fail")
+  } else if d != NilDataInstance {
+    t.Errorf("UnmarshalSoyData with trailing data returned %T %v, expected NilDataInstance", d, d)
+  }
+}
+
+func TestNumbersEqualSymmetry(t *testing.T) {
+  i3, f3, f35 := NewIntegerData(3), NewFloat64Data(3.0), NewFloat64Data(3.5)
+  assertBoolEquals(t, true, i3.Equals(NewIntegerData(3)), "int == int")
+  assertBoolEquals(t, true, i3.Equals(f3), "int == float")
+  assertBoolEquals(t, true, f3.Equals(i3), "float == int")
+  assertBoolEquals(t, true, f3.Equals(NewFloat64Data(3.0)), "float == float")
+  assertBoolEquals(t, false, i3.Equals(f35), "3 == 3.5")
+  assertBoolEquals(t, false, f35.Equals(i3), "3.5 == 3")
+  assertBoolEquals(t, true, NumbersEqual(i3, f3), "NumbersEqual(3, 3.0)")
+  assertBoolEquals(t, true, NumbersEqual(f3, i3), "NumbersEqual(3.0, 3)")
+  assertBoolEquals(t, false, NumbersEqual(i3, NewStringData("3")), "NumbersEqual with a string")
+}
+
+func TestRegisterContentKindProcessor(t *testing.T) {
+  RegisterContentKindProcessor(CONTENT_KIND_URI, func(s string) string {
+    return strings.Replace(s, "http://cdn.example.com/", "https://cdn.example.com/", 1)
+  })
+  defer RegisterContentKindProcessor(CONTENT_KIND_URI, nil)
+  assertStringEquals(t, "https://cdn.example.com/a.png", NewSanitizedContent("http://cdn.example.com/a.png", CONTENT_KIND_URI).String(), "URI processor runs for URI content")
+  assertStringEquals(t, "http://cdn.example.com/a.png", NewSanitizedContent("http://cdn.example.com/a.png", CONTENT_KIND_HTML).String(), "URI processor does not run for HTML content")
+  RegisterContentKindProcessor(CONTENT_KIND_URI, nil)
+  assertStringEquals(t, "http://cdn.example.com/a.png", NewSanitizedContent("http://cdn.example.com/a.png", CONTENT_KIND_URI).String(), "URI processor after it is removed")
+}
+
+func TestContentKindProcessorRunsOnSanitizerOutput(t *testing.T) {
+  calls := 0
+  RegisterContentKindProcessor(CONTENT_KIND_HTML_ATTRIBUTE, func(s string) string {
+    calls++
+    return strings.Replace(s, "http://cdn.example.com/", "https://cdn.example.com/", -1)
+  })
+  defer RegisterContentKindProcessor(CONTENT_KIND_HTML_ATTRIBUTE, nil)
+  attrs := BuildAttributes(NewSoyMapDataFromArgs("src", "http://cdn.example.com/a.png"))
+  assertStringEquals(t, "src=\"https://cdn.example.com/a.png\"", attrs.String(), "processor runs on BuildAttributes output")
+  assertIntEquals(t, 1, calls, "processor calls for BuildAttributes")
+}
+
+func TestInt64Data(t *testing.T) {
+  big := NewInt64Data(9007199254740993)
+  assertStringEquals(t, "9007199254740993", big.String(), "Int64Data.String()")
+  assertStringEquals(t, "9007199254740993", big.StringValue(), "Int64Data.StringValue()")
+  d := ToSoyDataNoErr(int64(9007199254740993))
+  if _, ok := d.(Int64Data); !ok {
+    t.Errorf("ToSoyData(int64) is of type %T, expected Int64Data", d)
+  }
+  assertStringEquals(t, "9007199254740993", d.String(), "ToSoyData(int64) keeps its precision")
+  if _, ok := ToSoyDataNoErr(int64(1)).(Int64Data); !ok {
+    t.Errorf("ToSoyData(int64(1)) is of type %T, expected Int64Data", ToSoyDataNoErr(int64(1)))
+  }
+  u := ToSoyDataNoErr(uint64(9007199254740993))
+  if _, ok := u.(Int64Data); !ok {
+    t.Errorf("ToSoyData(uint64) is of type %T, expected Int64Data", u)
+  }
+  assertStringEquals(t, "9007199254740993", u.String(), "ToSoyData(uint64) keeps its precision")
+  if v, err := ToSoyData(uint64(math.MaxUint64)); err == nil {
+    t.Errorf("ToSoyData(MaxUint64) returned %v, expected an error", v)
+  }
+  assertBoolEquals(t, false, NewInt64Data(1 << 32).Equals(NewBooleanData(false)), "Int64Data(2^32) == false")
+  assertBoolEquals(t, true, NewInt64Data(1).Equals(NewBooleanData(true)), "Int64Data(1) == true")
+  assertBoolEquals(t, false, big.Equals(NewInt64Data(9007199254740992)), "Int64Data values one apart")
+  assertBoolEquals(t, true, big.Equals(NewInt64Data(9007199254740993)), "equal Int64Data values")
+  assertBoolEquals(t, true, NewInt64Data(3).Equals(NewIntegerData(3)), "Int64Data == IntegerData")
+  assertBoolEquals(t, true, NewIntegerData(3).Equals(NewInt64Data(3)), "IntegerData == Int64Data")
+  assertBoolEquals(t, true, NewFloat64Data(3).Equals(NewInt64Data(3)), "Float64Data == Int64Data")
+  assertBoolEquals(t, true, NewInt64Data(3).Equals(NewFloat64Data(3)), "Int64Data == Float64Data")
+  assertBoolEquals(t, true, LessThan(NewInt64Data(9007199254740992), big).Value(), "LessThan compares Int64Data exactly")
+  assertBoolEquals(t, false, GreaterThanOrEqual(NewInt64Data(9007199254740992), big).Bool(), "GreaterThanOrEqual compares Int64Data exactly")
+  b, _ := MarshalSoyData(NewSoyListDataFromArgs(big))
+  assertStringEquals(t, "[9007199254740993]", string(b), "MarshalSoyData(Int64Data)")
+}
+
+func TestSanitizedContentHasContent(t *testing.T) {
+  sc := NewSanitizedContent("<b>x</b>", CONTENT_KIND_HTML)
+  assertBoolEquals(t, true, sc.HasContent("<b>x</b>"), "HasContent with the same string")
+  assertBoolEquals(t, false, sc.HasContent("<b>y</b>"), "HasContent with a different string")
+  assertBoolEquals(t, true, ContentEquals(sc, "<b>x</b>"), "ContentEquals with the same string")
+  assertBoolEquals(t, false, ContentEquals(sc, "&lt;b&gt;x&lt;/b&gt;"), "ContentEquals with a different string")
+  assertBoolEquals(t, false, ContentEquals(nil, ""), "ContentEquals with nil content")
+}
+
+func TestUndefinedData(t *testing.T) {
+  m := NewSoyMapDataFromArgs("present", nil, "nested", NewSoyMapDataFromArgs("value", 1))
+  assertBoolEquals(t, false, IsUndefined(m.Get("present")), "stored null is not undefined")
+  assertBoolEquals(t, true, IsUndefined(m.Get("absent")), "absent key is undefined")
+  assertBoolEquals(t, false, IsUndefined(GetData(m, "present")), "GetData of a stored null")
+  assertBoolEquals(t, true, IsUndefined(GetData(m, "absent")), "GetData of an absent key")
+  assertBoolEquals(t, true, IsUndefined(GetData(m, "nested.absent")), "GetData of an absent nested key")
+  assertBoolEquals(t, false, IsUndefined(GetData(m, "nested.value")), "GetData of a present nested key")
+  assertBoolEquals(t, false, IsUndefined(nil), "IsUndefined(nil)")
+  assertStringEquals(t, "undefined", UndefinedDataInstance.String(), "UndefinedData.String()")
+  assertStringEquals(t, "null", NilDataInstance.String(), "NilData.String()")
+  assertBoolEquals(t, false, UndefinedDataInstance.Bool(), "UndefinedData is falsy")
+  assertBoolEquals(t, true, NilDataInstance.Equals(NilDataInstance), "null == null")
+  assertBoolEquals(t, true, NilDataInstance.Equals(UndefinedDataInstance), "null == undefined")
+  assertBoolEquals(t, true, UndefinedDataInstance.Equals(NilDataInstance), "undefined == null")
+  assertBoolEquals(t, false, NewStringData("undefined").Equals(UndefinedDataInstance), "a string is not undefined")
+  if d := UndefinedDataInstance.SoyData(); d != UndefinedDataInstance {
+    t.Errorf("UndefinedData.SoyData() is %T %v, expected UndefinedDataInstance", d, d)
+  }
+}
+
+type taggedUser struct {
+  UserID int `soy:"userId"`
+  Email string `json:"email,omitempty"`
+  Nickname string `soy:"nick" json:"nickname"`
+  Password string `soy:"-"`
+  Token string `json:"-"`
+  Age int `json:",omitempty"`
+  Name string
+}
+
+func TestToSoyDataStructTags(t *testing.T) {
+  m, ok := ToSoyDataNoErr(taggedUser{UserID: 7, Email: "a@b.c", Nickname: "al", Password: "secret", Token: "t", Age: 30, Name: "Al"}).(SoyMapData)
+  if !ok {
+    t.Fatalf("ToSoyData(struct) should return a SoyMapData")
+  }
+  assertIntEquals(t, 5, m.Len(), "number of converted fields")
+  assertIntEquals(t, 7, m.Get("userId").IntegerValue(), "field named by a soy tag")
+  assertStringEquals(t, "a@b.c", m.Get("email").String(), "field named by a json tag with options")
+  assertStringEquals(t, "al", m.Get("nick").String(), "soy tag takes precedence over json tag")
+  assertBoolEquals(t, false, m.Contains("Password"), "field skipped by soy:\"-\"")
+  assertBoolEquals(t, false, m.Contains("Token"), "field skipped by json:\"-\"")
+  assertIntEquals(t, 30, m.Get("Age").IntegerValue(), "field with only tag options keeps its name")
+  assertStringEquals(t, "Al", m.Get("Name").String(), "untagged field")
+}
+
+func TestToSoyDataError(t *testing.T) {
+  d := ToSoyDataNoErr(fmt.Errorf("field %s is required", "email"))
+  if _, ok := d.(StringData); !ok {
+    t.Errorf("ToSoyData(error) is of type %T, expected StringData", d)
+  }
+  assertStringEquals(t, "field email is required", d.String(), "ToSoyData(error)")
+}
+
+type mixedFields struct {
+  Visible string
+  hidden string
+  Count int
+  secret int
+}
+
+func TestToSoyDataSkipsUnexportedFields(t *testing.T) {
+  d, err := ToSoyData(mixedFields{Visible: "yes", hidden: "no", Count: 2, secret: 3})
+  if err != nil {
+    t.Fatalf("ToSoyData of a struct with unexported fields failed: %v", err)
+  }
+  m := d.(SoyMapData)
+  assertIntEquals(t, 2, m.Len(), "only exported fields are converted")
+  assertStringEquals(t, "yes", m.Get("Visible").String(), "exported string field")
+  assertIntEquals(t, 2, m.Get("Count").IntegerValue(), "exported int field")
+  assertBoolEquals(t, false, m.Contains("hidden"), "unexported string field")
+  assertBoolEquals(t, false, m.Contains("secret"), "unexported int field")
+}
+
+type pointedTo struct {
+  Name string
+  Next *pointedTo
+}
+
+func TestToSoyDataPointers(t *testing.T) {
+  d, err := ToSoyData(&pointedTo{Name: "first", Next: &pointedTo{Name: "second"}})
+  if err != nil {
+    t.Fatalf("ToSoyData of a pointer failed: %v", err)
+  }
+  assertStringEquals(t, "first", GetData(d, "Name").String(), "field of a pointed-to struct")
+  assertStringEquals(t, "second", GetData(d, "Next.Name").String(), "field of a nested pointer")
+  assertSoyDataEquals(t, NilDataInstance, GetData(d, "Next.Next"), "nil pointer field")
+  var nilPointer *pointedTo
+  d, err = ToSoyData(nilPointer)
+  if err != nil {
+    t.Fatalf("ToSoyData of a nil pointer failed: %v", err)
+  }
+  assertSoyDataEquals(t, NilDataInstance, d, "ToSoyData of a nil pointer")
+  l, err := ToSoyData([]*pointedTo{{Name: "a"}, nil, {Name: "c"}})
+  if err != nil {
+    t.Fatalf("ToSoyData of a slice of pointers failed: %v", err)
+  }
+  assertIntEquals(t, 3, l.(SoyListData).Len(), "length of a converted slice of pointers")
+  assertStringEquals(t, "a", GetData(l, "0.Name").String(), "first element of a slice of pointers")
+  assertSoyDataEquals(t, NilDataInstance, GetData(l, "1"), "nil element of a slice of pointers")
+  assertStringEquals(t, "c", GetData(l, "2.Name").String(), "last element of a slice of pointers")
+}
+
+func TestToSoyDataCycles(t *testing.T) {
+  first := &pointedTo{Name: "first"}
+  first.Next = &pointedTo{Name: "second", Next: first}
+  d, err := ToSoyData(first)
+  if err != nil {
+    t.Fatalf("ToSoyData of a cyclic structure failed: %v", err)
+  }
+  assertStringEquals(t, "second", GetData(d, "Next.Name").String(), "value before the cycle")
+  assertSoyDataEquals(t, NilDataInstance, GetData(d, "Next.Next"), "pointer back to the root")
+  assertBoolEquals(t, false, IsUndefined(GetData(d, "Next.Next")), "pointer back to the root is null")
+
+  m := map[string]interface{}{"name": "m"}
+  m["self"] = m
+  d, err = ToSoyData(m)
+  if err != nil {
+    t.Fatalf("ToSoyData of a map containing itself failed: %v", err)
+  }
+  assertSoyDataEquals(t, NilDataInstance, GetData(d, "self"), "map containing itself")
+
+  shared := &pointedTo{Name: "shared"}
+  d, _ = ToSoyData([]*pointedTo{shared, shared})
+  assertStringEquals(t, "shared", GetData(d, "1.Name").String(), "a value referenced twice without a cycle")
+}
+
+func TestSoyListDataToSlice(t *testing.T) {
+  l := NewSoyListDataFromArgs("a", 2, 3.5, true, nil)
+  arr := SoyListDataToSlice(l)
+  assertIntEquals(t, 5, len(arr), "SoyListDataToSlice length")
+  assertSoyDataEquals(t, NewStringData("a"), arr[0], "SoyListDataToSlice first element")
+  assertSoyDataEquals(t, NewFloat64Data(3.5), arr[2], "SoyListDataToSlice middle element")
+  back := NewSoyListDataFromVector(arr)
+  assertIntEquals(t, l.Len(), back.Len(), "length after a round trip")
+  for i := 0; i < l.Len(); i++ {
+    assertSoyDataEquals(t, l.At(i), back.At(i), "element after a round trip")
+  }
+  assertIntEquals(t, 0, len(SoyListDataToSlice(nil)), "SoyListDataToSlice(nil)")
+}
+
+func TestSoyMapDataDeleteAndSortedKeys(t *testing.T) {
+  m := NewSoyMapDataFromArgs("pear", 1, "apple", 2, "fig", 3, "Banana", 4)
+  assertStringEquals(t, "Banana,apple,fig,pear", strings.Join(m.SortedKeys(), ","), "SortedKeys")
+  m.Delete("fig")
+  assertBoolEquals(t, false, m.Contains("fig"), "Contains after Delete")
+  assertIntEquals(t, 3, m.Len(), "Len after Delete")
+  m.Delete("missing")
+  assertIntEquals(t, 3, m.Len(), "Len after deleting an absent key")
+  assertStringEquals(t, "Banana,apple,pear", strings.Join(m.SortedKeys(), ","), "SortedKeys after Delete")
+}
+
+func TestStringDataHashCode(t *testing.T) {
+  assertIntEquals(t, 0, NewStringData("").HashCode(), "hash of the empty string")
+  assertIntEquals(t, 99162322, NewStringData("hello").HashCode(), "hash matches Java's String.hashCode()")
+  assertIntEquals(t, NewStringData("same").HashCode(), NewStringData("sa" + "me").HashCode(), "equal strings have equal hashes")
+  assertBoolEquals(t, true, NewStringData("a").HashCode() != NewStringData("b").HashCode(), "different strings have different hashes")
+  assertBoolEquals(t, true, NewStringData("ab").HashCode() != NewStringData("ba").HashCode(), "hash depends on order")
+  assertIntEquals(t, 1772899, NewStringData("\U0001F600").HashCode(), "hash of a supplementary character uses surrogates")
+}
+
+func TestSoyListDataEqualsElementwise(t *testing.T) {
+  a := NewSoyListDataFromArgs("x", 1, 2.5, true, nil, NewSoyListDataFromArgs("nested"))
+  b := NewSoyListDataFromArgs(NewStringData("x"), NewIntegerData(1), NewFloat64Data(2.5), NewBooleanData(true), NilDataInstance, NewSoyListDataFromArgs("nested"))
+  assertBoolEquals(t, true, a.Equals(b), "independently built equal lists")
+  assertBoolEquals(t, true, b.Equals(a), "independently built equal lists, reversed")
+  assertBoolEquals(t, false, a.Equals(NewSoyListDataFromArgs("x", 1, 2.5, true, nil, NewSoyListDataFromArgs("other"))), "lists differing in a nested element")
+  assertBoolEquals(t, false, NewSoyListDataFromArgs("x").Equals(NewSoyListDataFromArgs("y")), "lists differing in an element")
+  assertBoolEquals(t, false, NewSoyListData().Equals(NilDataInstance), "empty list compared to null")
+}
+
+func TestSoyListDataSetAtRemoveAt(t *testing.T) {
+  l := NewSoyListDataFromArgs("a", "b", "c")
+  if err := l.SetAt(1, NewStringData("B")); err != nil {
+    t.Errorf("SetAt in range failed: %v", err)
+  }
+  assertStringEquals(t, "B", l.At(1).String(), "value after SetAt")
+  if err := l.SetAt(3, NewStringData("d")); err == nil {
+    t.Errorf("SetAt past the end should fail")
+  }
+  if err := l.SetAt(-1, NewStringData("d")); err == nil {
+    t.Errorf("SetAt with a negative index should fail")
+  }
+  assertStringEquals(t, "a", l.RemoveAt(0).String(), "RemoveAt returns the removed value")
+  assertIntEquals(t, 2, l.Len(), "Len after RemoveAt")
+  assertStringEquals(t, "B", l.At(0).String(), "first value after RemoveAt")
+  assertSoyDataEquals(t, NilDataInstance, l.RemoveAt(5), "RemoveAt out of range")
+  assertIntEquals(t, 2, l.Len(), "Len after RemoveAt out of range")
+
+  empty := NewSoyListData()
+  assertSoyDataEquals(t, NilDataInstance, empty.RemoveAt(0), "RemoveAt on an empty list")
+  if err := empty.SetAt(0, NewIntegerData(1)); err == nil {
+    t.Errorf("SetAt on an empty list should fail")
+  }
+  if err := NilDataInstance.SetAt(0, NewIntegerData(1)); err != nil {
+    t.Errorf("SetAt on null should do nothing but got: %v", err)
+  }
+  if r := NilDataInstance.RemoveAt(0); r != NilDataInstance {
+    t.Errorf("RemoveAt on null is %T %v, expected NilDataInstance", r, r)
+  }
+}
+
+func TestOrderedSoyMapData(t *testing.T) {
+  m := NewOrderedSoyMapDataFromPairs("zebra", 1, "apple", 2, "mango", 3)
+  assertIntEquals(t, 3, m.Len(), "Len")
+  assertStringEquals(t, "[zebra apple mango]", fmt.Sprint(m.Keys()), "Keys keeps insertion order")
+  m.Set("banana", NewIntegerData(4))
+  m.Set("zebra", NewIntegerData(5))
+  assertStringEquals(t, "[zebra apple mango banana]", fmt.Sprint(m.Keys()), "Keys after Set")
+  assertSoyDataEquals(t, NewIntegerData(5), m.Get("zebra"), "Set on an existing key replaces the value")
+  m.Delete("apple")
+  m.Delete("missing")
+  var visited []string
+  m.ForEach(func(key string, value SoyData) bool {
+    visited = append(visited, key + "=" + value.String())
+    return true
+  })
+  assertStringEquals(t, "[zebra=5 mango=3 banana=4]", fmt.Sprint(visited), "ForEach order")
+  visited = nil
+  m.ForEach(func(key string, value SoyData) bool {
+    visited = append(visited, key)
+    return false
+  })
+  assertStringEquals(t, "[zebra]", fmt.Sprint(visited), "ForEach stops when fn returns false")
+  assertBoolEquals(t, true, IsUndefined(m.Get("apple")), "Get of a deleted key")
+
+  b, err := json.Marshal(m)
+  if err != nil {
+    t.Fatalf("json.Marshal failed: %v", err)
+  }
+  assertStringEquals(t, `{"zebra":5,"mango":3,"banana":4}`, string(b), "MarshalJSON keeps insertion order")
+  assertStringEquals(t, `{"zebra": 5, "mango": 3, "banana": 4}`, m.String(), "String keeps insertion order")
+
+  plain := NewSoyMapDataFromArgs("banana", 4, "mango", 3, "zebra", 5)
+  assertBoolEquals(t, true, m.Equals(plain), "OrderedSoyMapData equals a SoyMapData with the same entries")
+  assertBoolEquals(t, true, plain.Equals(m), "SoyMapData equals an OrderedSoyMapData with the same entries")
+  assertBoolEquals(t, false, NewSoyMapDataFromArgs("banana", 4).Equals(m), "SoyMapData with fewer entries")
+  assertBoolEquals(t, true, m.Equals(NewOrderedSoyMapDataFromPairs("banana", 4, "mango", 3, "zebra", 5)), "order is not significant to Equals")
+  assertStringEquals(t, "3", GetData(NewSoyMapDataFromArgs("m", m), "m.mango").String(), "GetData through an ordered map")
+
+  c := DeepCopy(m).(*OrderedSoyMapData)
+  c.Set("kiwi", NewIntegerData(6))
+  assertStringEquals(t, "[zebra mango banana kiwi]", fmt.Sprint(c.Keys()), "DeepCopy keeps the key order")
+  assertIntEquals(t, 3, m.Len(), "DeepCopy does not share entries")
+}
+
+func TestSanitizedContentAppend(t *testing.T) {
+  html := NewSanitizedContent("<b>", CONTENT_KIND_HTML)
+  if err := html.Append(NewSanitizedContent("bold</b>", CONTENT_KIND_HTML)); err != nil {
+    t.Errorf("Append of matching content failed: %v", err)
+  }
+  assertStringEquals(t, "<b>bold</b>", html.Content(), "content after Append")
+  if err := html.Append(NewSanitizedContent("http://example.com/", CONTENT_KIND_URI)); err == nil {
+    t.Errorf("Append of URI content to HTML content should fail")
+  }
+  assertStringEquals(t, "<b>bold</b>", html.Content(), "content after a rejected Append")
+  if err := html.Append(nil); err != nil {
+    t.Errorf("Append(nil) failed: %v", err)
+  }
+
+  joined, err := html.AppendNew(NewSanitizedContent("<br>", CONTENT_KIND_HTML))
+  if err != nil {
+    t.Fatalf("AppendNew of matching content failed: %v", err)
+  }
+  assertStringEquals(t, "<b>bold</b><br>", joined.Content(), "AppendNew result")
+  assertBoolEquals(t, true, joined.ContentKind() == CONTENT_KIND_HTML, "AppendNew keeps the content kind")
+  assertStringEquals(t, "<b>bold</b>", html.Content(), "AppendNew leaves the receiver unchanged")
+  if _, err := html.AppendNew(NewSanitizedContent("x", CONTENT_KIND_URI)); err == nil {
+    t.Errorf("AppendNew of URI content to HTML content should fail")
+  }
+}
+
+func TestToSoyDataLimited(t *testing.T) {
+  big := make([]int, 100)
+  if _, err := ToSoyDataLimited(big, 50); err == nil {
+    t.Errorf("ToSoyDataLimited of 101 values with a limit of 50 should fail")
+  } else if _, ok := err.(*SoyDataException); !ok {
+    t.Errorf("ToSoyDataLimited should fail with a SoyDataException but got %T", err)
+  }
+  nested := map[string]interface{}{"a": []interface{}{1, 2, map[string]int{"x": 1, "y": 2}}}
+  if _, err := ToSoyDataLimited(nested, 5); err == nil {
+    t.Errorf("ToSoyDataLimited of a nested structure over the limit should fail")
+  }
+
+  d, err := ToSoyDataLimited(big, 101)
+  if err != nil {
+    t.Fatalf("ToSoyDataLimited of 101 values with a limit of 101 failed: %v", err)
+  }
+  assertIntEquals(t, 100, d.(SoyListData).Len(), "ToSoyDataLimited within the limit")
+  d, err = ToSoyDataLimited(nested, 100)
+  if err != nil {
+    t.Fatalf("ToSoyDataLimited of a nested structure within the limit failed: %v", err)
+  }
+  assertIntEquals(t, 2, GetData(d, "a.2.y").IntegerValue(), "ToSoyDataLimited of a nested structure")
+  d, err = ToSoyDataLimited(map[int]int{1: 2}, 2)
+  if err != nil {
+    t.Fatalf("ToSoyDataLimited should not count map keys but failed: %v", err)
+  }
+  assertIntEquals(t, 2, GetData(d, "1").IntegerValue(), "ToSoyDataLimited of a map with int keys")
+}
+
+func TestToDataOfAbsentKeys(t *testing.T) {
+  m := NewSoyMapDataFromArgs("a", NewSoyMapDataFromArgs("b", 1))
+  for _, missing := range []SoyData{m.Get("missing"), GetData(m, "a.missing"), UndefinedDataInstance, NilDataInstance} {
+    assertBoolEquals(t, false, ToBooleanData(missing).Bool(), "ToBooleanData of an absent key")
+    assertIntEquals(t, 0, ToIntegerData(missing).IntegerValue(), "ToIntegerData of an absent key")
+    assertFloat64Equals(t, 0, ToFloat64Data(missing).Float64Value(), "ToFloat64Data of an absent key")
+    assertStringEquals(t, "", ToStringData(missing).StringValue(), "ToStringData of an absent key")
+    l := ToSoyListData(missing)
+    if _, ok := l.(*UndefinedData); ok {
+      t.Errorf("ToSoyListData of an absent key returned the undefined value itself")
+    }
+    assertIntEquals(t, 0, l.Len(), "ToSoyListData of an absent key")
+    l.PushBack(NewIntegerData(1))
+    assertIntEquals(t, 1, l.Len(), "ToSoyListData of an absent key is a new list")
+    mm := ToSoyMapData(missing)
+    assertIntEquals(t, 0, mm.Len(), "ToSoyMapData of an absent key")
+    mm.Set("x", NewIntegerData(1))
+  }
+}
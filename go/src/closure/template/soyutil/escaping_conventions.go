@@ -11,6 +11,8 @@ import (
   "sort"
   "strconv"
   "strings"
+  "sync"
+  "unicode/utf8"
 )
 
 const (
@@ -37,10 +39,7 @@ var (
   }
   
   CSS_WORD = regexp.MustCompile(
-    // See http://www.owasp.org/index.php/XSS_(Cross_Site_Scripting)_Prevention_Cheat_Sheet
-    // #RULE_.234_-_CSS_Escape_Before_Inserting_Untrusted_Data_into_HTML_Style_Property_Values
-    // for an explanation of why expression and moz-binding are bad.
-    "^(?!-*(?:(expression|(?:moz-)?binding))(?:" +
+    "(?i)^(?:" +
       // A latin class name or ID, CSS identifier, hex color or unicode range.
       "[.#]?-?(?:[_a-zA-Z0-9-]+)(?:-[_a-zA-Z0-9-]+)*-?|" +
       // A quantity
@@ -49,9 +48,18 @@ var (
       "!important|" +
       // Nothing.
       "" +
-    ")\\z/i",
+    ")\\z",
   )
   
+  /**
+   * Words that match {@link CSS_WORD} but must still be rejected.  Go's regexp has no negative
+   * lookahead, so this is checked separately.
+   * See http://www.owasp.org/index.php/XSS_(Cross_Site_Scripting)_Prevention_Cheat_Sheet
+   * #RULE_.234_-_CSS_Escape_Before_Inserting_Untrusted_Data_into_HTML_Style_Property_Values
+   * for an explanation of why expression and moz-binding are bad.
+   */
+  _CSS_WORD_REJECT_RE = regexp.MustCompile("(?i)^-*(?:expression|(?:moz-)?binding)")
+  
   /**
    * Loose matcher for HTML tags, DOCTYPEs, and HTML comments.
    * This will reliably find HTML tags (though not CDATA tags and not XML tags whose name or
@@ -75,26 +83,40 @@ var (
   
   
   _FILTER_NORMALIZE_URI_RE = regexp.MustCompile(
-    "^(?:(?:https?|mailto):|[^&:\\/?#]*(?:[\\/?#]|\\z))/i",
+    "(?i)^(?:(?:https?|mailto):|[^&:\\/?#]*(?:[\\/?#]|\\z))",
+  )
+  
+  /**
+   * Like {@link _FILTER_NORMALIZE_URI_RE} but for the source of an image, video or audio element,
+   * so it also allows FTP and base64 encoded image data URIs.
+   */
+  _FILTER_NORMALIZE_MEDIA_URI_RE = regexp.MustCompile(
+    "(?i)^(?:(?:https?|ftp):|data:image/[a-z0-9+]+;base64,[a-z0-9+/]+=*\\z|" +
+    "[^&:\\/?#]*(?:[\\/?#]|\\z))",
   )
   
   _FILTER_HTML_ATTRIBUTE_RE = regexp.MustCompile(
-    "^" +
-    // Disallow special attribute names
-    "(?!style|on|action|archive|background|cite|classid|codebase|data|dsync|href" +
-    "|longdesc|src|usemap)" +
+    "(?i)^" +
     "(?:" +
     // Must match letters
     "[a-z0-9_$:-]*" +
     // Match until the end.
-    ")\\z/i",
+    ")\\z",
+  )
+  
+  /** Disallow special attribute names. */
+  _FILTER_HTML_ATTRIBUTE_REJECT_RE = regexp.MustCompile(
+    "(?i)^(?:style|on|action|archive|background|cite|classid|codebase|data|dsync|href" +
+    "|longdesc|src|usemap)",
   )
   
   _FILTER_HTML_ELEMENT_NAME_RE = regexp.MustCompile(
-    "^" +
-    // Disallow special element names.
-    "(?!script|style|title|textarea|xmp|no)" +
-    "[a-z0-9_$:-]*\\z/i",
+    "(?i)^[a-z0-9_$:-]*\\z",
+  )
+  
+  /** Disallow special element names. */
+  _FILTER_HTML_ELEMENT_NAME_REJECT_RE = regexp.MustCompile(
+    "(?i)^(?:script|style|title|textarea|xmp|no)",
   )
 )
 
@@ -103,15 +125,20 @@ var (
 var (
   /** Implements the {@code |escapeHtml} directive. */
   EscapeHtmlInstance = newEscapeHtmlEscaper()
+  EscapeHtmlRcdataInstance = newEscapeHtmlRcdataEscaper()
+  EscapeHtmlAposInstance = newEscapeHtmlAposEscaper()
+  EscapeHtmlEscapeSlashInstance = newEscapeHtmlSlashEscaper()
   NormalizeHtmlInstance = newNormalizeHtmlEscaper()
   EscapeHtmlNospaceInstance = newEscapeHtmlNospaceEscaper()
   NormalizeHtmlNospaceInstance = newNormalizeHtmlNospaceEscaper()
   EscapeJsStringInstance = newEscapeJsStringEscaper()
+  EscapeJsStringJsonInstance = newEscapeJsStringJsonEscaper()
   EscapeJsRegexInstance = newEscapeJsRegexEscaper()
   EscapeCssStringInstance = newEscapeCssStringEscaper()
   FilterCssValueInstance = newFilterCssValueEscaper()
   NormalizeUriInstance = newNormalizeUriEscaper()
   FilterNormalizeUriInstance = newFilterNormalizeUriEscaper()
+  FilterNormalizeMediaUriInstance = newFilterNormalizeMediaUriEscaper()
   EscapeUriInstance = newEscapeUriEscaper()
   FilterHtmlAttributeInstance = newFilterHtmlAttributeEscaper()
   FilterHtmlElementNameInstance = newFilterHtmlElementNameEscaper()
@@ -210,17 +237,101 @@ func (p *appendableEscapedWriter) Close() (error) {
 }
 
 
+/**
+ * Size of the chunks an escapingReader pulls from its source.
+ */
+const _ESCAPE_READER_CHUNK_SIZE = 4096
+
+type escapingReader struct {
+  clsx *crossLanguageStringXform
+  r io.Reader
+  chunk []byte
+  // Bytes read from r that end in an incomplete UTF-8 sequence and are not yet escaped.
+  pending []byte
+  out bytes.Buffer
+  err error
+}
+
+func newEscapingReader(clsx *crossLanguageStringXform, r io.Reader) io.Reader {
+  return &escapingReader {
+    clsx: clsx,
+    r: r,
+  }
+}
+
+func (p *escapingReader) Read(b []byte) (int, error) {
+  for p.out.Len() == 0 && p.err == nil {
+    p.fill()
+  }
+  if p.out.Len() > 0 {
+    return p.out.Read(b)
+  }
+  return 0, p.err
+}
+
+/**
+ * Reads one chunk from the source and escapes every complete rune read so far onto out.  A
+ * trailing partial rune is kept in pending until the rest of it arrives or the source ends.
+ */
+func (p *escapingReader) fill() {
+  if p.chunk == nil {
+    p.chunk = make([]byte, _ESCAPE_READER_CHUNK_SIZE)
+  }
+  n, err := p.r.Read(p.chunk)
+  p.pending = append(p.pending, p.chunk[:n]...)
+  end := len(p.pending)
+  if err == nil {
+    end = completeRunesLen(p.pending)
+  }
+  if end > 0 {
+    if _, escErr := p.clsx.maybeEscapeOnto(string(p.pending[:end]), &p.out); escErr != nil && err == nil {
+      err = escErr
+    }
+    p.pending = append(p.pending[:0], p.pending[end:]...)
+  }
+  p.err = err
+}
+
+/**
+ * Returns the length of the longest prefix of b that does not end partway through a UTF-8
+ * sequence.
+ */
+func completeRunesLen(b []byte) int {
+  for i := len(b) - 1; i >= 0 && i >= len(b) - utf8.UTFMax; i-- {
+    if utf8.RuneStart(b[i]) {
+      if utf8.FullRune(b[i:]) {
+        return len(b)
+      }
+      return i
+    }
+  }
+  return len(b)
+}
+
+
 type defineEscapers interface {
   DefineEscapes() []Escape
 }
 
+/**
+ * The part of an escaper needed to escape content, small enough for middleware to accept any
+ * escaper, including the built-in *Instance escapers, without depending on the rest of
+ * CrossLanguageStringXform.
+ */
+type Escaper interface {
+  Escape(s string) (string, error)
+  EscapedWriter(w io.Writer) (io.Writer)
+}
+
 type CrossLanguageStringXform interface {
+  Escaper
   DirectiveName() string
   ValueFilter() *regexp.Regexp
+  MatchesValueFilter(s string) bool
   NonAsciiPrefix() string
   Escapes() []Escape
-  Escape(s string) (string, error)
-  EscapedWriter(w io.Writer) (io.Writer)
+  EscapeFor(r rune) (string, bool)
+  WillEscape(s string) bool
   DefineEscapes() []Escape
 }
 
@@ -250,6 +361,11 @@ type CrossLanguageStringXform interface {
 type crossLanguageStringXform struct {
   directiveName string
   valueFilter *regexp.Regexp
+  /**
+   * Null, or a regular expression matching strings that pass {@link #valueFilter} but must
+   * still be rejected, standing in for the negative lookahead that Go's regexp lacks.
+   */
+  valueRejectFilter *regexp.Regexp
   jsNames []string
   escapes []Escape
   
@@ -355,6 +471,17 @@ func (p* crossLanguageStringXform) ValueFilter() *regexp.Regexp {
   return p.valueFilter
 }
 
+/**
+ * Whether s passes {@link #ValueFilter} and is not rejected by the value reject filter.
+ * True for all strings if the escaper has no value filter.
+ */
+func (p* crossLanguageStringXform) MatchesValueFilter(s string) bool {
+  if p.valueFilter != nil && !p.valueFilter.MatchString(s) {
+    return false
+  }
+  return p.valueRejectFilter == nil || !p.valueRejectFilter.MatchString(s)
+}
+
 /**
  * The names of existing JavaScript builtins or Google Closure functions that implement
  * the escaping convention.
@@ -371,25 +498,131 @@ func (p* crossLanguageStringXform) Escapes() []Escape {
   return p.escapes
 }
 
+/**
+ * The escaped text for a single character.
+ * Uses the dense mapping for ASCII and the sparse mapping, then the {@link #nonAsciiPrefix},
+ * for everything else.
+ * @return The escaped text and true, or the empty string and false if r is not escaped.
+ */
+func (p* crossLanguageStringXform) EscapeFor(r rune) (string, bool) {
+  if r >= 0 && int(r) < len(p.escapesByCodeUnit) {
+    esc := p.escapesByCodeUnit[r]
+    return esc, esc != ""
+  }
+  if r < 0x80 {
+    return "", false
+  }
+  index := sort.SearchInts(p.nonAsciiCodeUnits, int(r))
+  if index < len(p.nonAsciiCodeUnits) && p.nonAsciiCodeUnits[index] == int(r) {
+    return p.nonAsciiEscapes[index], true
+  }
+  if p.nonAsciiPrefix != "" {
+    buf := bytes.NewBuffer(make([]byte, 0, 12))
+    p.escapeUsingPrefix(r, buf)
+    return buf.String(), true
+  }
+  return "", false
+}
+
+/**
+ * Whether {@link #Escape} would change s.
+ * This does not allocate, so callers can use it to skip escaping already-safe strings.
+ */
+func (p* crossLanguageStringXform) WillEscape(s string) bool {
+  escapesByCodeUnitLen := len(p.escapesByCodeUnit)
+  for _, c := range s {
+    if int(c) < escapesByCodeUnitLen {
+      if p.escapesByCodeUnit[c] != "" {
+        return true
+      }
+    } else if c >= 0x80 {
+      if p.nonAsciiPrefix != "" {
+        return true
+      }
+      index := sort.SearchInts(p.nonAsciiCodeUnits, int(c))
+      if index < len(p.nonAsciiCodeUnits) && p.nonAsciiCodeUnits[index] == int(c) {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+
+/**
+ * Buffers for {@link #Escape} to escape into, so that escaping does not allocate a new buffer
+ * for every string.
+ */
+var escapeBufferPool = sync.Pool{
+  New: func() interface{} {
+    return new(bytes.Buffer)
+  },
+}
+
+/**
+ * Buffers that have grown larger than this are not returned to the pool, so that one huge
+ * string does not pin its memory for the life of the process.
+ */
+const _MAX_POOLED_ESCAPE_BUFFER_SIZE = 64 * 1024
+
+func getEscapeBuffer(size int) *bytes.Buffer {
+  buf := escapeBufferPool.Get().(*bytes.Buffer)
+  buf.Reset()
+  buf.Grow(size)
+  return buf
+}
+
+/**
+ * Returns the escaped string held by buf, the output of maybeEscapeOnto when no buffer was
+ * passed in, and releases buf to the pool.
+ */
+func takeEscapedString(out io.Writer) string {
+  buf := out.(*bytes.Buffer)
+  value := buf.String()
+  if buf.Cap() <= _MAX_POOLED_ESCAPE_BUFFER_SIZE {
+    escapeBufferPool.Put(buf)
+  }
+  return value
+}
 
 // Methods that satisfy the Escaper interface.
 func (p* crossLanguageStringXform) Escape(s string) (string, error) {
   // We pass null so that we don't unnecessarily allocate (and zero) or copy char arrays.
   buf, err := p.maybeEscapeOnto(s, nil)
   if buf != nil {
-    if sbuf, ok := buf.(stringer); ok {
-      return sbuf.String(), err
-    }
+    return takeEscapedString(buf), err
   }
   return s, err
 }
 
 
+/**
+ * Like {@link #Escape} but also reports whether any character was escaped.  The input is only
+ * scanned once.
+ */
+func (p* crossLanguageStringXform) EscapeCounted(s string) (string, bool, error) {
+  buf, err := p.maybeEscapeOnto(s, nil)
+  if buf != nil {
+    return takeEscapedString(buf), true, err
+  }
+  return s, false, err
+}
+
+
 func (p* crossLanguageStringXform) EscapedWriter(w io.Writer) (io.Writer) {
   return newAppendableEscapedWriter(p, w)
 }
 
 
+/**
+ * Returns a reader that yields the escaped form of everything read from r.  Input is escaped a
+ * chunk at a time as it is read, so large content never has to be held in memory all at once.
+ */
+func (p* crossLanguageStringXform) EscapeReader(r io.Reader) io.Reader {
+  return newEscapingReader(p, r)
+}
+
+
 /**
  * Escapes the given char sequence onto the given buffer iff it contains characters that need to
  * be escaped.
@@ -412,42 +645,37 @@ func (p *crossLanguageStringXform) maybeEscapeOntoSubstring(s string, out io.Wri
   escapesByCodeUnitLen := len(p.escapesByCodeUnit)
   for j, c := range s[start:end] {
     i := start + j
+    var esc string
+    usePrefix := false
     if int(c) < escapesByCodeUnitLen {  // Use the dense map.
-      esc := p.escapesByCodeUnit[c];
-      if esc != "" {
-        if out == nil {
-          // Create a new buffer if we need to escape a character in s.
-          // We add 32 to the size to leave a decent amount of space for escape characters.
-          out = bytes.NewBuffer(make([]byte, 0))
-        }
-        _, err = io.WriteString(out, s[pos:i])
-        if err != nil { return out, err }
-        _, err = io.WriteString(out, esc);
-        if err != nil { return out, err }
-        pos = i + 1
-      }
+      esc = p.escapesByCodeUnit[c]
     } else if c >= 0x80 {  // Use the sparse map.
       index := sort.SearchInts(p.nonAsciiCodeUnits, int(c))
-      if index >= 0 {
-        if out == nil {
-          out = bytes.NewBuffer(make([]byte, 0))
-        }
-        _, err = io.WriteString(out, s[pos:i])
-        if err != nil { return out, err }
-        _, err = io.WriteString(out, p.nonAsciiEscapes[index])
-        if err != nil { return out, err }
-        pos = i + 1
+      if index < len(p.nonAsciiCodeUnits) && p.nonAsciiCodeUnits[index] == int(c) {
+        esc = p.nonAsciiEscapes[index]
       } else if p.nonAsciiPrefix != "" {  // Fallback to the prefix based escaping.
-        if out == nil {
-          out = bytes.NewBuffer(make([]byte, 0))
-        }
-        _, err = io.WriteString(out, s[pos:i])
-        if err != nil { return out, err }
-        err = p.escapeUsingPrefix(c, out)
-        if err != nil { return out, err }
-        pos = i + 1
+        usePrefix = true
       }
     }
+    if esc == "" && !usePrefix {
+      continue
+    }
+    if out == nil {
+      // Get a buffer if we need to escape a character in s.
+      // We add 32 to the size to leave a decent amount of space for escape characters.
+      out = getEscapeBuffer(end - start + 32)
+    }
+    _, err = io.WriteString(out, s[pos:i])
+    if err != nil { return out, err }
+    if usePrefix {
+      err = p.escapeUsingPrefix(c, out)
+    } else {
+      _, err = io.WriteString(out, esc)
+    }
+    if err != nil { return out, err }
+    // Skip all the bytes of a multi-byte character, not just the first.
+    _, size := utf8.DecodeRuneInString(s[i:end])
+    pos = i + size
   }
   if out != nil {
     _, err = io.WriteString(out, s[pos:end])
@@ -656,6 +884,104 @@ func (p *escapeHtmlEscaper) DefineEscapes() []Escape {
 }
 
 
+/**
+ * Like {@link escapeHtmlEscaper} but escapes ' to the XML entity &apos; for XHTML and XML output.
+ * HTML 4 does not define &apos;, which is why {@code |escapeHtml} uses &#39; instead.
+ */
+type escapeHtmlAposEscaper struct {
+  crossLanguageStringXform
+}
+
+func newEscapeHtmlAposEscaper() *escapeHtmlAposEscaper {
+  p := new(escapeHtmlAposEscaper)
+  initCrossLanguageStringXform(
+    &p.crossLanguageStringXform,
+    "EscapeHtmlApos",
+    nil,
+    []string{},
+    "",
+    p,
+  )
+  return p
+}
+
+func (p *escapeHtmlAposEscaper) DefineEscapes() []Escape {
+  escapes := EscapeHtmlInstance.DefineEscapes()
+  arr := make([]Escape, len(escapes))
+  for i, esc := range escapes {
+    if esc.PlainText() == '\'' {
+      arr[i] = NewEscape('\'', "&apos;")
+    } else {
+      arr[i] = esc
+    }
+  }
+  return arr
+}
+
+/**
+ * Like {@link escapeHtmlEscaper} but also escapes the solidus to &#47; for output whose context
+ * is not fully known, so that the output cannot form an end tag like {@code </script>}.
+ */
+type escapeHtmlSlashEscaper struct {
+  crossLanguageStringXform
+}
+
+func newEscapeHtmlSlashEscaper() *escapeHtmlSlashEscaper {
+  p := new(escapeHtmlSlashEscaper)
+  initCrossLanguageStringXform(
+    &p.crossLanguageStringXform,
+    "EscapeHtmlEscapeSlash",
+    nil,
+    []string{},
+    "",
+    p,
+  )
+  return p
+}
+
+func (p *escapeHtmlSlashEscaper) DefineEscapes() []Escape {
+  escapes := append(EscapeHtmlInstance.DefineEscapes(), NewEscape('/', "&#47;"))
+  // initCrossLanguageStringXform depends on the escapes being sorted.
+  sort.Slice(escapes, func(i, j int) bool {
+    return escapes[i].CompareTo(escapes[j]) < 0
+  })
+  return escapes
+}
+
+
+/**
+ * Implements the {@code |escapeHtmlRcdata} directive which allows arbitrary content to be
+ * included inside RCDATA elements like {@code <textarea>} and {@code <title>}.
+ * Only the characters that can change the RCDATA tokenizer state, and NUL, are escaped, so
+ * quotes are left alone.
+ */
+type escapeHtmlRcdataEscaper struct {
+  crossLanguageStringXform
+}
+
+func newEscapeHtmlRcdataEscaper() *escapeHtmlRcdataEscaper {
+  p := new(escapeHtmlRcdataEscaper)
+  initCrossLanguageStringXform(
+    &p.crossLanguageStringXform,
+    "EscapeHtmlRcdata",
+    nil,
+    []string{},
+    "",
+    p,
+  )
+  return p
+}
+
+func (p *escapeHtmlRcdataEscaper) DefineEscapes() []Escape {
+  escapes := newHtmlEscapeListBuilder().
+    EscapeWithValue('&', "&amp;").
+    EscapeWithValue('<', "&lt;").
+    Escape('\000').
+    Build()
+  return escapes
+}
+
+
 /**
  * A directive that encodes any HTML special characters that can appear in RCDATA unescaped but
  * that can be escaped without changing semantics.
@@ -900,6 +1226,41 @@ func (p *escapeJsStringEscaper) DefineEscapes() []Escape {
 
 
 
+/**
+ * Like {@link escapeJsStringEscaper} but uses {@code \uNNNN} instead of {@code \xNN} for
+ * numeric escapes, so the output is also valid JSON string content.
+ */
+type escapeJsStringJsonEscaper struct {
+  crossLanguageStringXform
+}
+
+func newEscapeJsStringJsonEscaper() *escapeJsStringJsonEscaper {
+  p := new(escapeJsStringJsonEscaper)
+  initCrossLanguageStringXform(
+    &p.crossLanguageStringXform,
+    "EscapeJsStringJson",
+    nil,
+    []string{},
+    "",
+    p,
+  )
+  return p
+}
+
+func (p *escapeJsStringJsonEscaper) DefineEscapes() []Escape {
+  escapes := EscapeJsStringInstance.DefineEscapes()
+  arr := make([]Escape, len(escapes))
+  for i, esc := range escapes {
+    if strings.HasPrefix(esc.Escaped(), "\\x") {
+      arr[i] = NewEscape(esc.PlainText(), fmt.Sprintf("\\u%04x", esc.PlainText()))
+    } else {
+      arr[i] = esc
+    }
+  }
+  return arr
+}
+
+
 /**
 * Implements the {@code |escapeJsRegex} directive which allows arbitrary content
 * to be included inside a JavaScript regular expression.
@@ -912,7 +1273,7 @@ func newEscapeJsRegexEscaper() *escapeJsRegexEscaper {
   p := new(escapeJsRegexEscaper)
   initCrossLanguageStringXform(
     &p.crossLanguageStringXform,
-    "EscapeJsString",
+    "EscapeJsRegex",
     nil,
     []string{},
     "",
@@ -968,7 +1329,7 @@ func newCssEscapeListBuilder() *cssEscapeListBuilder {
 }
 
 func (p* cssEscapeListBuilder) NumericEscapeFor(plainText rune) (s string) {
-  return "\\" + strconv.FormatInt(int64(plainText), 16)
+  return "\\" + strconv.FormatInt(int64(plainText), 16) + " "
 }
 
 /**
@@ -1028,6 +1389,7 @@ func newFilterCssValueEscaper() *filterCssValueEscaper {
     "",
     p,
   )
+  p.valueRejectFilter = _CSS_WORD_REJECT_RE
   return p
 }
 
@@ -1148,6 +1510,30 @@ func (p *filterNormalizeUriEscaper) DefineEscapes() []Escape {
   return NormalizeUriInstance.DefineEscapes()
 }
 
+/**
+ * Like {@link FilterNormalizeUri} but for media sources, so image data URIs are allowed.
+ */
+type filterNormalizeMediaUriEscaper struct {
+  crossLanguageStringXform
+}
+
+func newFilterNormalizeMediaUriEscaper() *filterNormalizeMediaUriEscaper {
+  p := new(filterNormalizeMediaUriEscaper)
+  initCrossLanguageStringXform(
+    &p.crossLanguageStringXform,
+    "FilterNormalizeMediaUri",
+    _FILTER_NORMALIZE_MEDIA_URI_RE,
+    []string{},
+    "",
+    p,
+  )
+  return p
+}
+
+func (p *filterNormalizeMediaUriEscaper) DefineEscapes() []Escape {
+  return NormalizeUriInstance.DefineEscapes()
+}
+
 /**
  * Implements the {@code |escapeUri} directive which allows arbitrary content to be included in a
  * URI regardless of the string delimiters of the the surrounding language.
@@ -1207,6 +1593,7 @@ func newFilterHtmlAttributeEscaper() *filterHtmlAttributeEscaper {
     "",
     p,
   )
+  p.valueRejectFilter = _FILTER_HTML_ATTRIBUTE_REJECT_RE
   return p
 }
 
@@ -1232,6 +1619,7 @@ func newFilterHtmlElementNameEscaper() *filterHtmlElementNameEscaper {
     "",
     p,
   )
+  p.valueRejectFilter = _FILTER_HTML_ELEMENT_NAME_REJECT_RE
   return p
 }
 
@@ -1245,20 +1633,49 @@ func (p *filterHtmlElementNameEscaper) DefineEscapes() []Escape {
 func AllEscapers() []CrossLanguageStringXform {
   return []CrossLanguageStringXform {
     EscapeHtmlInstance,
+    EscapeHtmlRcdataInstance,
+    EscapeHtmlAposInstance,
+    EscapeHtmlEscapeSlashInstance,
     NormalizeHtmlInstance,
     EscapeHtmlNospaceInstance,
     EscapeJsStringInstance,
+    EscapeJsStringJsonInstance,
     EscapeJsRegexInstance,
     EscapeCssStringInstance,
     FilterCssValueInstance,
     EscapeUriInstance,
     NormalizeUriInstance,
     FilterNormalizeUriInstance,
+    FilterNormalizeMediaUriInstance,
     FilterHtmlAttributeInstance,
     FilterHtmlElementNameInstance,
   }
 }
 
+var (
+  escapersByName map[string]CrossLanguageStringXform
+  escapersByNameOnce sync.Once
+)
+
+/**
+ * Looks up one of {@link AllEscapers} by its directive name, e.g. "|escapeHtml".  The leading
+ * pipe is optional.
+ */
+func EscaperByName(name string) (CrossLanguageStringXform, bool) {
+  escapersByNameOnce.Do(func() {
+    escapers := AllEscapers()
+    escapersByName = make(map[string]CrossLanguageStringXform, len(escapers))
+    for _, escaper := range escapers {
+      escapersByName[escaper.DirectiveName()] = escaper
+    }
+  })
+  if !strings.HasPrefix(name, "|") {
+    name = "|" + name
+  }
+  escaper, ok := escapersByName[name]
+  return escaper, ok
+}
+
 
 /**
  * Convert an ASCII string to full-width.
@@ -1279,3 +1696,24 @@ func toFullWidth(ascii string) string {
   }
   return string(chars)
 }
+
+
+/**
+ * Convert the full-width versions of printable ASCII characters back to ASCII.
+ * The inverse of {@link toFullWidth}, so that {@code "\uFF4A\uFF53\uFF1A"} becomes
+ * {@code "js:"}.  Other characters are unchanged.
+ */
+func toHalfWidth(s string) string {
+  for _, ch := range s {
+    if ch >= 0xff01 && ch <= 0xff5e {
+      chars := []rune(s)
+      for i, ch := range chars {
+        if ch >= 0xff01 && ch <= 0xff5e {
+          chars[i] = ch - 0xff00 + 0x20
+        }
+      }
+      return string(chars)
+    }
+  }
+  return s
+}
@@ -1,11 +1,66 @@
 package soyutil;
 
+import (
+  "encoding/json"
+  "fmt"
+  "strconv"
+  "strings"
+  "sync"
+  "sync/atomic"
+)
+
 type SanitizedContent struct {
   content string
   contentKind ContentKind
 }
 
+// SanitizedContent must be usable anywhere a SoyData is, e.g. as a value in a SoyMapData.
+var _ SoyData = (*SanitizedContent)(nil)
+
+/**
+ * The processors registered with RegisterContentKindProcessor, as a
+ * map[ContentKind]func(string) string that is replaced rather than modified so that
+ * NewSanitizedContent can read it without locking.
+ */
+var (
+  contentKindProcessors atomic.Value
+  contentKindProcessorsLock sync.Mutex
+)
+
+/**
+ * Registers a transformation, e.g. CSS minification or URI rewriting, that is applied to all
+ * SanitizedContent of the given kind when it is built, both by NewSanitizedContent and by the
+ * sanitizers in this package that return SanitizedContent, such as BuildAttributes and
+ * TruncateHtml.  A later registration for the same kind replaces the earlier one, and a nil fn
+ * removes it.
+ * <p>
+ * Processors are global and see content that is already escaped, so fn must keep the
+ * content-kind contract: its output has to be as safe in the kind's context as its input, e.g.
+ * it must never decode entities in HTML.  Content may be wrapped more than once, so fn should
+ * be idempotent.
+ */
+func RegisterContentKindProcessor(kind ContentKind, fn func(string) string) {
+  contentKindProcessorsLock.Lock()
+  defer contentKindProcessorsLock.Unlock()
+  old, _ := contentKindProcessors.Load().(map[ContentKind]func(string) string)
+  processors := make(map[ContentKind]func(string) string, len(old) + 1)
+  for k, v := range old {
+    processors[k] = v
+  }
+  if fn == nil {
+    delete(processors, kind)
+  } else {
+    processors[kind] = fn
+  }
+  contentKindProcessors.Store(processors)
+}
+
 func NewSanitizedContent(content string, contentKind ContentKind) *SanitizedContent {
+  if processors, _ := contentKindProcessors.Load().(map[ContentKind]func(string) string); len(processors) > 0 {
+    if fn, ok := processors[contentKind]; ok {
+      content = fn(content)
+    }
+  }
   return &SanitizedContent{
     content: content,
     contentKind: contentKind,
@@ -20,6 +75,53 @@ func (p *SanitizedContent) ContentKind() ContentKind {
   return p.contentKind
 }
 
+/**
+ * Whether s is exactly this content, so callers can avoid wrapping the same string again.
+ */
+func (p *SanitizedContent) HasContent(s string) bool {
+  return p != nil && p.content == s
+}
+
+/**
+ * Whether sc is non-nil and its content is exactly s.
+ */
+func ContentEquals(sc *SanitizedContent, s string) bool {
+  return sc.HasContent(s)
+}
+
+/**
+ * Appends other's content to this content, e.g. to build up HTML a piece at a time.  Content
+ * of one kind is only known to be safe next to content of the same kind, so other must have
+ * this content's kind.  Appending nil does nothing.
+ */
+func (p *SanitizedContent) Append(other *SanitizedContent) error {
+  if other == nil {
+    return nil
+  }
+  if p == nil {
+    return NewSoyDataException("Cannot append to nil sanitized content")
+  }
+  if other.contentKind != p.contentKind {
+    return NewSoyDataException(fmt.Sprintf("Cannot append %v content to %v content", other.contentKind, p.contentKind))
+  }
+  p.content += other.content
+  return nil
+}
+
+/**
+ * Like {@link Append} but returns the result as new content, leaving this content unchanged.
+ */
+func (p *SanitizedContent) AppendNew(other *SanitizedContent) (*SanitizedContent, error) {
+  if p == nil {
+    return nil, NewSoyDataException("Cannot append to nil sanitized content")
+  }
+  result := &SanitizedContent{content: p.content, contentKind: p.contentKind}
+  if err := result.Append(other); err != nil {
+    return nil, err
+  }
+  return result, nil
+}
+
 func (p *SanitizedContent) Bool() bool {
   return len(p.content) != 0
 }
@@ -28,20 +130,37 @@ func (p *SanitizedContent) BooleanValue() bool {
   return len(p.content) != 0
 }
 
+/**
+ * Parses the content as an integer, truncating a floating point value, so that numeric content
+ * can take part in arithmetic.  Returns 0 if the content is not numeric.
+ */
 func (p *SanitizedContent) IntegerValue() int {
-  return len(p.content)
+  s := strings.TrimSpace(p.content)
+  if i, err := strconv.Atoi(s); err == nil {
+    return i
+  }
+  if f, err := strconv.ParseFloat(s, 64); err == nil {
+    return int(f)
+  }
+  return defaultIntegerValue()
 }
 
 func (p *SanitizedContent) FloatValue() float32 {
-  return float32(len(p.content))
+  return float32(p.Float64Value())
 }
 
+/**
+ * Parses the content as a number.  Returns 0 if the content is not numeric.
+ */
 func (p *SanitizedContent) Float64Value() float64 {
-  return float64(len(p.content))
+  if f, err := strconv.ParseFloat(strings.TrimSpace(p.content), 64); err == nil {
+    return f
+  }
+  return defaultFloat64Value()
 }
 
 func (p *SanitizedContent) NumberValue() float64 {
-  return float64(len(p.content))
+  return p.Float64Value()
 }
 
 func (p *SanitizedContent) String() string {
@@ -56,6 +175,13 @@ func (p *SanitizedContent) SoyData() SoyData {
   return p
 }
 
+/**
+ * Encodes the content as a JSON string; the content kind is not included.
+ */
+func (p *SanitizedContent) MarshalJSON() ([]byte, error) {
+  return json.Marshal(p.content)
+}
+
 func (p *SanitizedContent) Equals(other interface{}) bool {
   if other == nil {
     return false
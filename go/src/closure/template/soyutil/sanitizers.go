@@ -4,19 +4,59 @@ import (
   "bytes"
   "io"
   "encoding/json"
+  "fmt"
+  "html"
+  "regexp"
+  "sort"
   "strconv"
   "strings"
-  "net/url"
 )
 
 /**
  * Converts the input to HTML by entity escaping.
  */
 func EscapeHtml(s string) string {
-  value, _ := EscapeHtmlInstance.Escape(s)
+  value, _ := EscapeHtmlErr(s)
   return value
 }
 
+/**
+ * Like {@link EscapeHtml} but also returns any error raised by the escaper.
+ */
+func EscapeHtmlErr(s string) (string, error) {
+  return EscapeHtmlInstance.Escape(s)
+}
+
+/**
+ * Like {@link EscapeHtml} but also escapes '/' to &#47;, for output whose context is not fully
+ * known.  EscapeHtml itself leaves '/' alone.
+ */
+func EscapeHtmlEscapeSlash(s string) string {
+  value, _ := EscapeHtmlEscapeSlashInstance.Escape(s)
+  return value
+}
+
+/**
+ * Like {@link EscapeHtml} but also reports whether anything was escaped.
+ */
+func EscapeHtmlCounted(s string) (string, bool) {
+  value, escaped, _ := EscapeHtmlInstance.EscapeCounted(s)
+  return value, escaped
+}
+
+
+/**
+ * Converts the input to HTML by entity escaping, choosing the entity used for the single quote.
+ * Pass true for useAposEntity to get &apos;, which is only defined for XHTML and XML, or false
+ * for the &#39; that {@link EscapeHtml} produces.
+ */
+func EscapeHtmlWithAposEntity(s string, useAposEntity bool) string {
+  if !useAposEntity {
+    return EscapeHtml(s)
+  }
+  value, _ := EscapeHtmlAposInstance.Escape(s)
+  return value
+}
 
 /**
  * Converts the input to HTML by entity escaping.
@@ -28,19 +68,50 @@ func EscapeHtmlSoyData(s SoyData) string {
   if v, ok := s.(*SanitizedContent); ok && v.contentKind == CONTENT_KIND_HTML {
     return v.String()
   }
-  return EscapeHtml(s.String())
+  str := s.String()
+  if !EscapeHtmlInstance.WillEscape(str) {
+    return str
+  }
+  return EscapeHtml(str)
+}
+
+/**
+ * Builds a short HTML preview of plain text.
+ * The text is escaped before it is truncated to maxLen visible characters (including a trailing
+ * "..." when anything was cut), so the cut can never fall inside an entity.
+ */
+func SafePreview(s string, maxLen int) *SanitizedContent {
+  return TruncateHtml(EscapeHtml(s), maxLen, true)
 }
 
 /**
  * Converts the input to HTML suitable for use inside {@code <textarea>} by entity escaping.
+ * Only {@code <}, {@code &}, and NUL are escaped since those are the only characters that are
+ * special in RCDATA.
  */
 func EscapeHtmlRcdata(s string) string {
-  value, _ := EscapeHtmlInstance.Escape(s)
+  value, _ := EscapeHtmlRcdataErr(s)
   return value
 }
 
+/**
+ * Like {@link EscapeHtmlRcdata} but also returns any error raised by the escaper.
+ */
+func EscapeHtmlRcdataErr(s string) (string, error) {
+  return EscapeHtmlRcdataInstance.Escape(s)
+}
+
+/**
+ * Like {@link EscapeHtmlRcdata} but also reports whether anything was escaped.
+ */
+func EscapeHtmlRcdataCounted(s string) (string, bool) {
+  value, escaped, _ := EscapeHtmlRcdataInstance.EscapeCounted(s)
+  return value, escaped
+}
+
 /**
  * Converts the input to HTML suitable for use inside {@code <textarea>} by entity escaping.
+ * HTML sanitized content is normalized rather than escaped so that its entities are preserved.
  */
 func EscapeHtmlRcdataSoyData(s SoyData) string {
   if s == nil {
@@ -57,10 +128,25 @@ func EscapeHtmlRcdataSoyData(s SoyData) string {
  * Normalizes HTML to HTML making sure quotes and other specials are entity encoded.
  */
 func NormalizeHtml(s string) string {
-  value, _ := NormalizeHtmlInstance.Escape(s)
+  value, _ := NormalizeHtmlErr(s)
   return value
 }
 
+/**
+ * Like {@link NormalizeHtml} but also returns any error raised by the escaper.
+ */
+func NormalizeHtmlErr(s string) (string, error) {
+  return NormalizeHtmlInstance.Escape(s)
+}
+
+/**
+ * Like {@link NormalizeHtml} but also reports whether anything was escaped.
+ */
+func NormalizeHtmlCounted(s string) (string, bool) {
+  value, escaped, _ := NormalizeHtmlInstance.EscapeCounted(s)
+  return value, escaped
+}
+
 /**
  * Normalizes HTML to HTML making sure quotes and other specials are entity encoded.
  */
@@ -77,10 +163,25 @@ func NormalizeHtmlSoyData(s SoyData) string {
  * so that the result can be safely embedded in a valueless attribute.
  */
 func NormalizeHtmlNospace(s string) string {
-  value, _ := NormalizeHtmlNospaceInstance.Escape(s)
+  value, _ := NormalizeHtmlNospaceErr(s)
   return value
 }
 
+/**
+ * Like {@link NormalizeHtmlNospace} but also returns any error raised by the escaper.
+ */
+func NormalizeHtmlNospaceErr(s string) (string, error) {
+  return NormalizeHtmlNospaceInstance.Escape(s)
+}
+
+/**
+ * Like {@link NormalizeHtmlNospace} but also reports whether anything was escaped.
+ */
+func NormalizeHtmlNospaceCounted(s string) (string, bool) {
+  value, escaped, _ := NormalizeHtmlNospaceInstance.EscapeCounted(s)
+  return value, escaped
+}
+
 /**
  * Normalizes HTML to HTML making sure quotes, spaces and other specials are entity encoded
  * so that the result can be safely embedded in a valueless attribute.
@@ -94,17 +195,45 @@ func NormalizeHtmlNospaceSoyData(s SoyData) string {
 
 
 /**
- * Converts the input to HTML by entity escaping, stripping tags in sanitized content so the
- * result can safely be embedded in an HTML attribute value.
+ * Converts plain text to HTML by entity escaping so the result can safely be embedded in an
+ * HTML attribute value.  Since s is plain text, tags in it are escaped rather than stripped,
+ * e.g. "<b>" becomes "&lt;b&gt;".  Use {@link EscapeHtmlAttributeStripping} for HTML, or
+ * {@link EscapeHtmlAttributeSoyData} which strips tags from HTML sanitized content.
  */
 func EscapeHtmlAttribute(s string) string {
-  value, _ := EscapeHtmlInstance.Escape(s)
+  value, _ := EscapeHtmlAttributeErr(s)
   return value
 }
 
+/**
+ * Like {@link EscapeHtmlAttribute} but also returns any error raised by the escaper.
+ */
+func EscapeHtmlAttributeErr(s string) (string, error) {
+  return EscapeHtmlInstance.Escape(s)
+}
+
+/**
+ * Like {@link EscapeHtmlAttribute} but also reports whether anything was escaped.
+ */
+func EscapeHtmlAttributeCounted(s string) (string, bool) {
+  value, escaped, _ := EscapeHtmlInstance.EscapeCounted(s)
+  return value, escaped
+}
+
+/**
+ * Converts HTML to the text of an HTML attribute value by stripping tags, e.g. "<b>bold</b>"
+ * becomes "bold", and normalizing the remaining text so it can safely be embedded in an HTML
+ * attribute value.  s must already be HTML; existing entities are kept as they are.
+ */
+func EscapeHtmlAttributeStripping(s string) string {
+  return StripHtmlTags(s, true)
+}
+
 /**
  * Converts the input to HTML by entity escaping, stripping tags in sanitized content so the
- * result can safely be embedded in an HTML attribute value.
+ * result can safely be embedded in an HTML attribute value.  Plain text is escaped as by
+ * {@link EscapeHtmlAttribute} and HTML sanitized content is stripped as by
+ * {@link EscapeHtmlAttributeStripping}.
  */
 func EscapeHtmlAttributeSoyData(s SoyData) string {
   if s == nil {
@@ -112,7 +241,7 @@ func EscapeHtmlAttributeSoyData(s SoyData) string {
   }
   if v, ok := s.(*SanitizedContent); ok && v.contentKind == CONTENT_KIND_HTML {
     // |escapeHtmlAttribute should only be used on attribute values that cannot have tags.
-    return StripHtmlTags(v.String(), true);
+    return EscapeHtmlAttributeStripping(v.String())
   }
   return EscapeHtmlAttribute(s.String())
 }
@@ -122,10 +251,25 @@ func EscapeHtmlAttributeSoyData(s SoyData) string {
  * result can safely be embedded in an unquoted HTML attribute value.
  */
 func EscapeHtmlAttributeNospace(s string) string {
-  value, _ := EscapeHtmlNospaceInstance.Escape(s)
+  value, _ := EscapeHtmlAttributeNospaceErr(s)
   return value
 }
 
+/**
+ * Like {@link EscapeHtmlAttributeNospace} but also returns any error raised by the escaper.
+ */
+func EscapeHtmlAttributeNospaceErr(s string) (string, error) {
+  return EscapeHtmlNospaceInstance.Escape(s)
+}
+
+/**
+ * Like {@link EscapeHtmlAttributeNospace} but also reports whether anything was escaped.
+ */
+func EscapeHtmlAttributeNospaceCounted(s string) (string, bool) {
+  value, escaped, _ := EscapeHtmlNospaceInstance.EscapeCounted(s)
+  return value, escaped
+}
+
 /**
  * Converts plain text to HTML by entity escaping, stripping tags in sanitized content so the
  * result can safely be embedded in an unquoted HTML attribute value.
@@ -145,7 +289,31 @@ func EscapeHtmlAttributeNospaceSoyData(s SoyData) string {
  * Converts the input to the body of a JavaScript string by using {@code \n} style escapes.
  */
 func EscapeJsString(s string) string {
-  value, _ := EscapeJsStringInstance.Escape(s)
+  value, _ := EscapeJsStringErr(s)
+  return value
+}
+
+/**
+ * Like {@link EscapeJsString} but also returns any error raised by the escaper.
+ */
+func EscapeJsStringErr(s string) (string, error) {
+  return EscapeJsStringInstance.Escape(s)
+}
+
+/**
+ * Like {@link EscapeJsString} but also reports whether anything was escaped.
+ */
+func EscapeJsStringCounted(s string) (string, bool) {
+  value, escaped, _ := EscapeJsStringInstance.EscapeCounted(s)
+  return value, escaped
+}
+
+/**
+ * Like {@link EscapeJsString} but always uses \uNNNN escapes, never \xNN, so the output is
+ * also valid JSON string content and passes linters that disallow \x escapes.
+ */
+func EscapeJsStringJson(s string) string {
+  value, _ := EscapeJsStringJsonInstance.Escape(s)
   return value
 }
 
@@ -184,6 +352,8 @@ func EscapeJsValueSoyData(s SoyData) string {
     return " null "
   } else if v, ok := s.(IntegerData); ok {
     return " " + strconv.Itoa(v.IntegerValue()) + " "
+  } else if v, ok := s.(Int64Data); ok {
+    return " " + v.String() + " "
   } else if v, ok := s.(Float64Data); ok {
     return " " + strconv.FormatFloat(v.Float64Value(), 'g', -1, 64) + " "
   } else if v, ok := s.(BooleanData); ok {
@@ -195,14 +365,75 @@ func EscapeJsValueSoyData(s SoyData) string {
   return EscapeJsValue(s.String())
 }
 
+/**
+ * Replaces the characters that could end a script block or a JavaScript line inside JSON text
+ * with their equivalent JSON string escapes.
+ */
+var _JSON_IN_HTML_REPLACER = strings.NewReplacer(
+  "<", "\\u003c",
+  ">", "\\u003e",
+  "&", "\\u0026",
+  "\u2028", "\\u2028",
+  "\u2029", "\\u2029",
+)
+
+/**
+ * Encodes a value as JSON that is safe to embed in an HTML block such as
+ * <script type="application/json">.  The characters <, >, &, U+2028 and U+2029 are always
+ * written as \uXXXX escapes so the data cannot close the element it is embedded in.
+ */
+func EscapeJsonInHtml(s SoyData) *SanitizedContent {
+  var output []byte
+  switch s.(type) {
+  case nil, NilData, *NilData, UndefinedData, *UndefinedData:
+    output = []byte("null")
+  default:
+    output, _ = json.Marshal(s)
+  }
+  return NewSanitizedContent(_JSON_IN_HTML_REPLACER.Replace(string(output)), CONTENT_KIND_HTML)
+}
+
+/**
+ * Builds a data-* attribute holding value as JSON, e.g. data-config="{&quot;a&quot;:1}", for
+ * scripts to read with JSON.parse(element.dataset.config).  The value is JSON encoded and then
+ * escaped for a quoted HTML attribute.
+ * @param name The attribute name without the "data-" prefix.  If it is not a valid data
+ *     attribute name the result is the innocuous output zSoyz.
+ */
+func BuildDataAttribute(name string, value SoyData) *SanitizedContent {
+  if !_DATA_ATTRIBUTE_NAME_RE.MatchString(name) {
+    return NewSanitizedContent(INNOCUOUS_OUTPUT, CONTENT_KIND_HTML_ATTRIBUTE)
+  }
+  output, err := MarshalSoyData(value)
+  if err != nil {
+    output = []byte("null")
+  }
+  return NewSanitizedContent("data-" + name + "=\"" + EscapeHtmlAttribute(string(output)) + "\"", CONTENT_KIND_HTML_ATTRIBUTE)
+}
+
 /**
  * Converts plain text to the body of a JavaScript regular expression literal.
  */
 func EscapeJsRegex(s string) string {
-  value, _ := EscapeJsRegexInstance.Escape(s)
+  value, _ := EscapeJsRegexErr(s)
   return value
 }
 
+/**
+ * Like {@link EscapeJsRegex} but also returns any error raised by the escaper.
+ */
+func EscapeJsRegexErr(s string) (string, error) {
+  return EscapeJsRegexInstance.Escape(s)
+}
+
+/**
+ * Like {@link EscapeJsRegex} but also reports whether anything was escaped.
+ */
+func EscapeJsRegexCounted(s string) (string, bool) {
+  value, escaped, _ := EscapeJsRegexInstance.EscapeCounted(s)
+  return value, escaped
+}
+
 /**
  * Converts plain text to the body of a JavaScript regular expression literal.
  */
@@ -217,10 +448,25 @@ func EscapeJsRegexSoyData(s SoyData) string {
  * Converts the input to the body of a CSS string literal.
  */
 func EscapeCssString(s string) string {
-  value, _ := EscapeCssStringInstance.Escape(s)
+  value, _ := EscapeCssStringErr(s)
   return value
 }
 
+/**
+ * Like {@link EscapeCssString} but also returns any error raised by the escaper.
+ */
+func EscapeCssStringErr(s string) (string, error) {
+  return EscapeCssStringInstance.Escape(s)
+}
+
+/**
+ * Like {@link EscapeCssString} but also reports whether anything was escaped.
+ */
+func EscapeCssStringCounted(s string) (string, bool) {
+  value, escaped, _ := EscapeCssStringInstance.EscapeCounted(s)
+  return value, escaped
+}
+
 /**
  * Converts the input to the body of a CSS string literal.
  */
@@ -236,12 +482,196 @@ func EscapeCssStringSoyData(s SoyData) string {
  * CSS keyword part.
  */
 func FilterCssValue(s string) string {
-  if FilterCssValueInstance.ValueFilter().MatchString(s) {
+  if !containsNul(s) && FilterCssValueInstance.MatchesValueFilter(s) {
     return s
   }
   return INNOCUOUS_OUTPUT
 }
 
+/**
+ * Like {@link FilterCssValue} but also accepts CSS custom properties: names like
+ * {@code --main-bg} and references like {@code var(--main-bg)} or {@code var(--main-bg, #fff)}.
+ * A fallback value must itself pass this filter, word by word, so expression(), bindings and
+ * url() are still rejected.
+ */
+func FilterCssValueWithVars(s string) string {
+  if FilterCssValue(s) != INNOCUOUS_OUTPUT || isSafeCssVar(s) {
+    return s
+  }
+  return INNOCUOUS_OUTPUT
+}
+
+/**
+ * Like {@link FilterCssValueWithVars} but accepts any SoyData.
+ */
+func FilterCssValueWithVarsSoyData(s SoyData) string {
+  switch s.(type) {
+  case nil, NilData, *NilData, UndefinedData, *UndefinedData:
+    return ""
+  }
+  return FilterCssValueWithVars(s.String())
+}
+
+func isSafeCssVar(s string) bool {
+  m := _CSS_VAR_RE.FindStringSubmatch(s)
+  if m == nil || FilterCssValue(m[1]) == INNOCUOUS_OUTPUT {
+    return false
+  }
+  if m[2] == "" || isSafeCssVar(m[2]) {
+    return true
+  }
+  for _, part := range strings.Fields(m[2]) {
+    if FilterCssValueWithVars(part) == INNOCUOUS_OUTPUT {
+      return false
+    }
+  }
+  return true
+}
+
+/**
+ * Builds a {@code style="..."} attribute from CSS property/value pairs.
+ * Property names must be CSS identifiers and each space separated part of a value must pass
+ * {@link FilterCssValue}; declarations that fail either check are dropped.  Declarations are
+ * emitted in property name order, separated by "; ".
+ */
+func BuildInlineStyle(decls map[string]string) *SanitizedContent {
+  names := make([]string, 0, len(decls))
+  for name := range decls {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  safeDecls := make([]string, 0, len(names))
+  for _, name := range names {
+    if decl, ok := filterCssDeclaration(name, decls[name]); ok {
+      safeDecls = append(safeDecls, decl)
+    }
+  }
+  return NewSanitizedContent("style=\"" + EscapeHtmlAttribute(strings.Join(safeDecls, "; ")) + "\"", CONTENT_KIND_HTML_ATTRIBUTE)
+}
+
+/**
+ * Checks one CSS declaration as {@link BuildInlineStyle} does.
+ * @return The normalized declaration, e.g. "color: red", and false if it is not safe.
+ */
+func filterCssDeclaration(name, value string) (string, bool) {
+  if !_CSS_PROPERTY_NAME_RE.MatchString(name) {
+    return "", false
+  }
+  parts := strings.Fields(value)
+  if len(parts) == 0 {
+    return "", false
+  }
+  for _, part := range parts {
+    if FilterCssValue(part) == INNOCUOUS_OUTPUT {
+      return "", false
+    }
+  }
+  return name + ": " + strings.Join(parts, " "), true
+}
+
+/**
+ * Builds a space separated list of attributes, e.g. for {@code <div {$attrs}>}, from a map of
+ * attribute names to values.  Attributes are emitted in name order and every value is quoted.
+ * <ul>
+ *   <li>Values of attributes that take a URI, such as href, src, formaction and xlink:href,
+ *       must pass {@link FilterNormalizeUri}, and are normalized.  Each candidate of a srcset
+ *       is checked the same way.</li>
+ *   <li>style values are split into declarations on ";", and each declaration is checked as
+ *       {@link BuildInlineStyle} does; unsafe declarations are dropped.</li>
+ *   <li>Other names must pass {@link FilterHtmlAttribute}, so event handlers and other
+ *       attributes that take script are dropped.  Their values are escaped as plain text.</li>
+ * </ul>
+ * Empty and otherwise invalid names are dropped.
+ */
+/**
+ * The attributes, besides those ending in ":href", "src", "url" or "uri", whose value is a URI.
+ */
+var _URI_ATTRIBUTE_NAMES = map[string]bool{
+  "action": true,
+  "background": true,
+  "cite": true,
+  "codebase": true,
+  "data": true,
+  "formaction": true,
+  "href": true,
+  "longdesc": true,
+  "poster": true,
+  "usemap": true,
+}
+
+/**
+ * Whether the lower case attribute name takes a URI value.
+ */
+func isUriAttributeName(name string) bool {
+  return _URI_ATTRIBUTE_NAMES[name] || strings.HasSuffix(name, ":href") ||
+      strings.HasSuffix(name, "src") || strings.HasSuffix(name, "url") ||
+      strings.HasSuffix(name, "uri")
+}
+
+/**
+ * Filters each comma separated candidate of a srcset value: its URI must pass
+ * {@link FilterNormalizeUri}, and a width or density descriptor is kept only if it is well
+ * formed.
+ */
+func filterSrcset(value string) string {
+  candidates := strings.Split(value, ",")
+  safe := make([]string, 0, len(candidates))
+  for _, candidate := range candidates {
+    fields := strings.Fields(candidate)
+    if len(fields) == 0 {
+      continue
+    }
+    uri := NormalizeUri(FilterNormalizeUri(fields[0]))
+    if len(fields) == 2 && _SRCSET_DESCRIPTOR_RE.MatchString(fields[1]) {
+      uri += " " + fields[1]
+    }
+    safe = append(safe, uri)
+  }
+  return strings.Join(safe, ", ")
+}
+
+func BuildAttributes(attrs SoyMapData) *SanitizedContent {
+  safeAttrs := make([]string, 0, len(attrs))
+  for _, name := range attrs.SortedKeys() {
+    value := attrs[name]
+    var text string
+    switch value.(type) {
+    case nil, NilData, *NilData, UndefinedData, *UndefinedData:
+    default:
+      text = value.String()
+    }
+    lowerName := strings.ToLower(name)
+    if lowerName == "" || !_FILTER_HTML_ATTRIBUTE_RE.MatchString(lowerName) {
+      continue
+    }
+    switch {
+    case lowerName == "srcset":
+      text = filterSrcset(text)
+    case isUriAttributeName(lowerName):
+      text = NormalizeUri(FilterNormalizeUri(text))
+    case lowerName == "style":
+      decls := strings.Split(text, ";")
+      safeDecls := make([]string, 0, len(decls))
+      for _, decl := range decls {
+        colon := strings.Index(decl, ":")
+        if colon < 0 {
+          continue
+        }
+        if safe, ok := filterCssDeclaration(strings.TrimSpace(decl[0:colon]), decl[colon+1:]); ok {
+          safeDecls = append(safeDecls, safe)
+        }
+      }
+      text = strings.Join(safeDecls, "; ")
+    default:
+      if FilterHtmlAttribute(name) == INNOCUOUS_OUTPUT {
+        continue
+      }
+    }
+    safeAttrs = append(safeAttrs, name + "=\"" + EscapeHtmlAttribute(text) + "\"")
+  }
+  return NewSanitizedContent(strings.Join(safeAttrs, " "), CONTENT_KIND_HTML_ATTRIBUTE)
+}
+
 /**
  * Makes sure that the input is a valid CSS identifier part, CLASS or ID part, quantity, or
  * CSS keyword part.
@@ -258,25 +688,38 @@ func FilterCssValueSoyData(s SoyData) string {
 
 
 /**
- * Escapes a string so that it can be safely included in a URI.
+ * Escapes a string so that it can be safely included in a URI, keeping RFC 3986 unreserved
+ * characters only: everything else, including the !'()* that JavaScript's encodeURIComponent
+ * leaves alone, is percent encoded, and a space becomes %20 rather than +.
  *
  * @param {*} str The string to be escaped. Can be other types, but the value
  *     will be coerced to a string.
  * @return {string} An escaped copy of the string.
 */
 func EscapeUri(s string) string {
-  // Checking if the search matches before calling encodeURIComponent avoids an
-  // extra allocation in IE6. This adds about 10us time in FF and a similiar
-  // over head in IE6 for lower working set apps, but for large working set
-  // apps, it saves about 70us per call.
-  if !_ENCODE_URI_RE.MatchString(s) {
-    return url.QueryEscape(s)
-  }
-  return s
+  value, _ := EscapeUriErr(s)
+  return value
+}
+
+/**
+ * Like {@link EscapeUri} but also returns any error raised by the escaper.
+ */
+func EscapeUriErr(s string) (string, error) {
+  return EscapeUriInstance.Escape(s)
+}
+
+/**
+ * Like {@link EscapeUri} but also reports whether anything was escaped.
+ */
+func EscapeUriCounted(s string) (string, bool) {
+  value, escaped, _ := EscapeUriInstance.EscapeCounted(s)
+  return value, escaped
 }
 
 /**
  * Converts the input to a piece of a URI by percent encoding assuming a UTF-8 encoding.
+ * URI sanitized content is trusted to be a whole URI, so it is only filtered and normalized;
+ * use {@link EscapeUriComponentSoyData} when the value is being placed in a query parameter.
  */
 func EscapeUriSoyData(s SoyData) string {
   if s == nil {
@@ -291,15 +734,63 @@ func EscapeUriSoyData(s SoyData) string {
 }
 
 
+/**
+ * Converts the input to a URI component, such as a query parameter value, by percent encoding.
+ * Unlike {@link EscapeUriSoyData}, URI sanitized content is encoded too since a URI nested in
+ * a query parameter must not have its delimiters interpreted by the outer URI.
+ */
+func EscapeUriComponentSoyData(s SoyData) string {
+  switch s.(type) {
+  case nil, NilData, *NilData, UndefinedData, *UndefinedData:
+    return ""
+  }
+  return EscapeUri(s.String())
+}
+
+/**
+ * Percent encodes a string for use as a URI path.  Unlike {@link EscapeUri}, '/' and the
+ * path-safe sub-delimiters ':', '@', '!', '$', '&', '*', '+', ',', ';' and '=' are left as is
+ * so a multi-segment path can be built in one step.  Quotes and parentheses are still encoded
+ * since they could end an attribute value or a CSS url().
+ */
+func EscapeUriPath(s string) string {
+  var buf bytes.Buffer
+  for i := 0; i < len(s); i++ {
+    c := s[i]
+    if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+        strings.IndexByte("-._~/:@!$&*+,;=", c) >= 0 {
+      buf.WriteByte(c)
+    } else {
+      buf.Write([]byte{'%', HEX_DIGITS[c >> 4], HEX_DIGITS[c & 0xf]})
+    }
+  }
+  return buf.String()
+}
+
 /**
  * Converts a piece of URI content to a piece of URI content that can be safely embedded
  * in an HTML attribute by percent encoding.
  */
 func NormalizeUri(s string) string {
-  value, _ := NormalizeUriInstance.Escape(s)
+  value, _ := NormalizeUriErr(s)
   return value
 }
 
+/**
+ * Like {@link NormalizeUri} but also returns any error raised by the escaper.
+ */
+func NormalizeUriErr(s string) (string, error) {
+  return NormalizeUriInstance.Escape(s)
+}
+
+/**
+ * Like {@link NormalizeUri} but also reports whether anything was escaped.
+ */
+func NormalizeUriCounted(s string) (string, bool) {
+  value, escaped, _ := NormalizeUriInstance.EscapeCounted(s)
+  return value, escaped
+}
+
 
 /**
  * Converts a piece of URI content to a piece of URI content that can be safely embedded
@@ -317,12 +808,29 @@ func NormalizeUriSoyData(s SoyData) string {
  * {@link #normalizeUri normalizes} it.
  */
 func FilterNormalizeUri(s string) string {
-  if FilterNormalizeUriInstance.ValueFilter().MatchString(s) {
+  if isSafeUri(s) {
     return s
   }
   return "#" + INNOCUOUS_OUTPUT
 }
 
+/**
+ * Whether the URI passes the {@code |filterNormalizeUri} protocol check.
+ * Full-width characters are folded to ASCII first so that a disguised scheme like
+ * {@code \uFF4A\uFF41\uFF56\uFF41...\uFF1A} is checked as {@code javascript:}.
+ */
+func isSafeUri(s string) bool {
+  return !containsNul(s) && FilterNormalizeUriInstance.MatchesValueFilter(toHalfWidth(s))
+}
+
+/**
+ * Whether s contains a NUL character.  The filters reject these since a NUL can truncate the
+ * string in downstream systems written in C, hiding whatever follows it from later checks.
+ */
+func containsNul(s string) bool {
+  return strings.IndexRune(s, 0) >= 0
+}
+
 /**
  * Makes sure that the given input doesn't specify a dangerous protocol and also
  * {@link #normalizeUri normalizes} it.
@@ -334,11 +842,117 @@ func FilterNormalizeUriSoyData(s SoyData) string {
   return FilterNormalizeUri(s.String())
 }
 
+/**
+ * Makes sure that the given input is safe as the source of an image, video or audio element and
+ * normalizes it.  Like {@link FilterNormalizeUri} but base64 encoded image data URIs, such as
+ * {@code data:image/png;base64,...}, and FTP URIs are allowed too.
+ * @return The normalized URI, or "about:invalid#zSoyz" if it is not allowed.
+ */
+func FilterNormalizeMediaUri(s string) string {
+  if containsNul(s) || !FilterNormalizeMediaUriInstance.MatchesValueFilter(toHalfWidth(s)) {
+    return "about:invalid#" + INNOCUOUS_OUTPUT
+  }
+  value, _ := FilterNormalizeMediaUriInstance.Escape(s)
+  return value
+}
+
+/**
+ * Like {@link FilterNormalizeMediaUri} but accepts any SoyData.
+ */
+func FilterNormalizeMediaUriSoyData(s SoyData) string {
+  switch s.(type) {
+  case nil, NilData, *NilData, UndefinedData, *UndefinedData:
+    return ""
+  }
+  return FilterNormalizeMediaUri(s.String())
+}
+
+/**
+ * Makes sure that the input is safe to load code or other resources from, e.g. as the
+ * {@code src} of a script or iframe or the {@code href} of a stylesheet.  Only absolute
+ * https: URLs, scheme-relative URLs such as {@code //cdn.example.com/a.js}, and absolute paths
+ * on the same origin such as {@code /js/a.js} are allowed.
+ * @return s, or "about:invalid#zSoyz" if it is not allowed.
+ */
+func FilterTrustedResourceUri(s string) string {
+  if _TRUSTED_RESOURCE_URI_RE.MatchString(s) {
+    return s
+  }
+  return "about:invalid#" + INNOCUOUS_OUTPUT
+}
+
+/**
+ * Like {@link FilterTrustedResourceUri} but accepts any SoyData.  Trusted resource URI
+ * sanitized content is returned as is.
+ */
+func FilterTrustedResourceUriSoyData(s SoyData) string {
+  switch v := s.(type) {
+  case nil, NilData, *NilData, UndefinedData, *UndefinedData:
+    return ""
+  case *SanitizedContent:
+    if v.contentKind == CONTENT_KIND_TRUSTED_RESOURCE_URI {
+      return v.String()
+    }
+  }
+  return FilterTrustedResourceUri(s.String())
+}
+
+/**
+ * Makes sure that the input is a base64 encoded image data URI, e.g. for an inline
+ * {@code <img src>}.  Only GIF, PNG, JPEG, WebP and BMP images are allowed.
+ * @return s, or the innocuous output zSoyz if it is not an image data URI.
+ */
+func FilterImageDataUri(s string) string {
+  if _IMAGE_DATA_URI_RE.MatchString(s) {
+    return s
+  }
+  return INNOCUOUS_OUTPUT
+}
+
+/**
+ * Like {@link FilterImageDataUri} but accepts any SoyData.  URI sanitized content is trusted
+ * and is returned as is.
+ */
+func FilterImageDataUriSoyData(s SoyData) string {
+  switch v := s.(type) {
+  case nil, NilData, *NilData, UndefinedData, *UndefinedData:
+    return ""
+  case *SanitizedContent:
+    if v.contentKind == CONTENT_KIND_URI {
+      return v.String()
+    }
+  }
+  return FilterImageDataUri(s.String())
+}
+
+/**
+ * Makes sure that the given input doesn't specify a dangerous protocol and normalizes it so it
+ * can be embedded inside an unquoted CSS {@code url(...)}.  Whitespace, parentheses, quotes, and
+ * backslashes are all percent encoded so that they cannot end the URL early.
+ */
+func FilterNormalizeCssUri(s string) string {
+  if !isSafeUri(s) {
+    return "#" + INNOCUOUS_OUTPUT
+  }
+  return NormalizeUri(s)
+}
+
+/**
+ * Makes sure that the given input doesn't specify a dangerous protocol and normalizes it so it
+ * can be embedded inside an unquoted CSS {@code url(...)}.
+ */
+func FilterNormalizeCssUriSoyData(s SoyData) string {
+  if s == nil {
+    return ""
+  }
+  return FilterNormalizeCssUri(s.String())
+}
+
 /**
  * Checks that the input is a valid HTML attribute name with normal keyword or textual content.
  */
 func FilterHtmlAttribute(s string) string {
-  if FilterHtmlAttributeInstance.ValueFilter().MatchString(s) {
+  if !containsNul(s) && FilterHtmlAttributeInstance.MatchesValueFilter(s) {
     return s
   }
   return INNOCUOUS_OUTPUT
@@ -365,7 +979,8 @@ func FilterHtmlAttributeSoyData(s SoyData) string {
         //    <div {$x}={$y}>.
         // If {$x} is "dir=ltr", and y is "foo" make sure the parser does not see the attribute
         // "dir=ltr=foo".
-        return content[0:eqIndex] + "=\"" + content[eqIndex + 1:] + "\""
+        // The value is escaped so that a quote inside it cannot end the quotes added here.
+        return content[0:eqIndex] + "=\"" + EscapeHtmlAttribute(content[eqIndex + 1:]) + "\""
       }
     }
   }
@@ -376,7 +991,7 @@ func FilterHtmlAttributeSoyData(s SoyData) string {
  * Checks that the input is part of the name of an innocuous element.
  */
 func FilterHtmlElementName(s string) string {
-  if FilterHtmlElementNameInstance.ValueFilter().MatchString(s) {
+  if !containsNul(s) && FilterHtmlElementNameInstance.MatchesValueFilter(s) {
     return s
   }
   return INNOCUOUS_OUTPUT
@@ -409,8 +1024,9 @@ func StripHtmlTags(value string, inQuotedAttribute bool) string {
   pos := 0
   match := HTML_TAG_CONTENT.FindStringIndex(value)
   for match != nil {
-    io.WriteString(normalizedOut, value[pos:match[0]])
-    pos = match[1]
+    // match is relative to value[pos:].
+    io.WriteString(normalizedOut, value[pos:pos + match[0]])
+    pos += match[1]
     match = HTML_TAG_CONTENT.FindStringIndex(value[pos:])
   }
   if pos < len(value) {
@@ -419,6 +1035,39 @@ func StripHtmlTags(value string, inQuotedAttribute bool) string {
   return buf.String()
 }
 
+/**
+ * Wraps JavaScript in a script element carrying a CSP nonce, i.e.
+ * <script nonce="...">...</script>.  js must already be trusted JavaScript, e.g. sanitized
+ * content of kind CONTENT_KIND_JS: it is not escaped, except that any "</script", in any case,
+ * becomes "<\/script" so the script cannot end the element early.  That means the same inside
+ * a JavaScript string, regular expression or comment.
+ * @param nonce The base64 nonce.  If it is not a valid token the result is the innocuous
+ *     output zSoyz.
+ */
+func BuildScriptTag(js SoyData, nonce string) *SanitizedContent {
+  if !_CSP_NONCE_RE.MatchString(nonce) {
+    return NewSanitizedContent(INNOCUOUS_OUTPUT, CONTENT_KIND_HTML)
+  }
+  body := ""
+  switch js.(type) {
+  case nil, NilData, *NilData, UndefinedData, *UndefinedData:
+  default:
+    body = _SCRIPT_END_TAG_RE.ReplaceAllStringFunc(js.String(), func(m string) string {
+      return "<\\/" + m[2:]
+    })
+  }
+  return NewSanitizedContent("<script nonce=\"" + nonce + "\">" + body + "</script>", CONTENT_KIND_HTML)
+}
+
+/**
+ * Extracts the readable text of HTML, e.g. for search indexing or previews: tags are removed
+ * and entities decoded, so "<b>a &amp; b</b>" becomes "a & b".  Unlike StripHtmlTags the
+ * result is plain text and must be escaped again before it is used as HTML.
+ */
+func ToPlainText(value string) string {
+  return html.UnescapeString(HTML_TAG_CONTENT.ReplaceAllString(value, ""))
+}
+
 /**
  * Escapes characters in the string to make it a valid content for a JS string literal.
  *
@@ -467,3 +1116,297 @@ func EscapeChar(c string) string {
 }
 
 
+
+
+var (
+  /**
+   * A CSS property name, including vendor prefixed names like {@code -moz-box-sizing}.
+   */
+  _CSS_PROPERTY_NAME_RE = regexp.MustCompile("^-?[a-zA-Z_][a-zA-Z0-9_-]*$")
+
+  /**
+   * A CSS custom property reference, capturing the property name and the optional fallback
+   * value, e.g. {@code var(--main-bg, #fff)}.
+   */
+  _CSS_VAR_RE = regexp.MustCompile("^var\\(\\s*(--[-_a-zA-Z0-9]+)\\s*(?:,\\s*(.*?))?\\s*\\)$")
+
+  /**
+   * The part of a data-* attribute name after "data-".  Upper case letters are not allowed since
+   * HTML lower cases attribute names.
+   */
+  _DATA_ATTRIBUTE_NAME_RE = regexp.MustCompile("^[a-z0-9_.-]+$")
+
+  /** A width or pixel density descriptor of a srcset candidate, e.g. "480w" or "1.5x". */
+  _SRCSET_DESCRIPTOR_RE = regexp.MustCompile("^(?:[0-9]+w|[0-9]+(?:\\.[0-9]+)?x)$")
+
+  /** A base64 encoded GIF, PNG, JPEG, WebP or BMP image data URI with a well formed body. */
+  _IMAGE_DATA_URI_RE = regexp.MustCompile(
+    "^(?i:data:image/(?:gif|png|jpeg|webp|bmp);base64,)" +
+    "(?:[A-Za-z0-9+/]{4})*(?:[A-Za-z0-9+/]{4}|[A-Za-z0-9+/]{3}=|[A-Za-z0-9+/]{2}==)$",
+  )
+
+  /**
+   * A URI that {@link FilterTrustedResourceUri} allows: an https: or scheme-relative URL with a
+   * host, or an absolute path on the same origin.
+   */
+  _TRUSTED_RESOURCE_URI_RE = regexp.MustCompile(
+    "^(?:(?i:https:)?//[^/?#\\\\\\x00-\\x20]+(?:[/?#][^\\x00-\\x20]*)?|" +
+    "/(?:[^/\\\\\\x00-\\x20][^\\x00-\\x20]*)?)$",
+  )
+
+  /** A CSP nonce: a base64 or base64url token with optional padding. */
+  _CSP_NONCE_RE = regexp.MustCompile("^[A-Za-z0-9+/_-]+={0,2}$")
+
+  /** The start of a script end tag, in any case. */
+  _SCRIPT_END_TAG_RE = regexp.MustCompile("(?i)</script")
+
+  /**
+   * Matches a single start or end tag in foreign (SVG or MathML) content, capturing the solidus of
+   * an end tag, the element name, the raw attribute text, and the solidus of a self-closing tag.
+   */
+  _FOREIGN_TAG_RE = regexp.MustCompile(
+    "<(/?)([a-zA-Z][a-zA-Z0-9:_-]*)((?:[^>'\"/]|\"[^\"]*\"|'[^']*'|/[^>])*)(/?)>",
+  )
+
+  /**
+   * Matches HTML comments, DOCTYPEs, CDATA sections, and processing instructions which are all
+   * dropped from foreign content.
+   */
+  _FOREIGN_MARKUP_DECL_RE = regexp.MustCompile(
+    "^<(?:!--(?:[^-]|-[^-]|--+[^->])*--+>|![^>]*>|\\?[^>]*>)",
+  )
+
+  /**
+   * Matches one attribute inside the raw attribute text captured by {@code _FOREIGN_TAG_RE}.
+   */
+  _FOREIGN_ATTRIBUTE_RE = regexp.MustCompile(
+    "([a-zA-Z_:][-a-zA-Z0-9_:.]*)(?:\\s*=\\s*(\"[^\"]*\"|'[^']*'|[^\\s\"'>]+))?",
+  )
+
+  /**
+   * Attribute values that could cause a fetch or run script even on an allowed attribute.
+   */
+  _FOREIGN_UNSAFE_VALUE_RE = regexp.MustCompile(
+    "(?i)(?:javascript|vbscript|data)\\s*:|expression\\s*\\(|url\\s*\\(\\s*[^#\\s]",
+  )
+
+  /**
+   * The SVG and MathML elements that are allowed through {@link CleanSvg}.
+   * Keys are lower case since element names are matched case-insensitively.
+   */
+  _SAFE_FOREIGN_ELEMENTS = map[string]bool{
+    // SVG
+    "svg": true, "g": true, "defs": true, "desc": true, "title": true, "symbol": true,
+    "circle": true, "ellipse": true, "line": true, "path": true, "polygon": true,
+    "polyline": true, "rect": true, "text": true, "tspan": true, "textpath": true,
+    "lineargradient": true, "radialgradient": true, "stop": true, "clippath": true,
+    "mask": true, "pattern": true, "marker": true,
+    // MathML
+    "math": true, "mi": true, "mn": true, "mo": true, "ms": true, "mtext": true,
+    "mrow": true, "mfrac": true, "msqrt": true, "mroot": true, "msub": true, "msup": true,
+    "msubsup": true, "munder": true, "mover": true, "munderover": true, "mtable": true,
+    "mtr": true, "mtd": true, "mspace": true, "mstyle": true, "mpadded": true,
+    "mphantom": true, "menclose": true, "semantics": true,
+  }
+
+  /**
+   * Elements whose content is dropped along with the element itself since the content is not
+   * foreign content and can carry script, e.g. HTML inside {@code <foreignObject>}.
+   */
+  _DROPPED_FOREIGN_ELEMENTS = map[string]bool{
+    "foreignobject": true, "script": true, "style": true, "annotation-xml": true,
+    "iframe": true, "object": true, "embed": true,
+  }
+
+  /**
+   * The attributes that are allowed on elements in {@code _SAFE_FOREIGN_ELEMENTS}.
+   * Event handlers, {@code style}, and anything that references another resource like
+   * {@code href} or {@code xlink:href} are deliberately absent.
+   */
+  _SAFE_FOREIGN_ATTRIBUTES = map[string]bool{
+    "id": true, "class": true, "xmlns": true, "version": true, "viewbox": true,
+    "preserveaspectratio": true, "width": true, "height": true, "x": true, "y": true,
+    "x1": true, "y1": true, "x2": true, "y2": true, "cx": true, "cy": true, "r": true,
+    "rx": true, "ry": true, "dx": true, "dy": true, "d": true, "points": true,
+    "transform": true, "fill": true, "fill-opacity": true, "fill-rule": true,
+    "clip-rule": true, "stroke": true, "stroke-width": true, "stroke-linecap": true,
+    "stroke-linejoin": true, "stroke-dasharray": true, "stroke-opacity": true,
+    "opacity": true, "offset": true, "stop-color": true, "stop-opacity": true,
+    "gradientunits": true, "gradienttransform": true, "font-family": true,
+    "font-size": true, "font-weight": true, "text-anchor": true, "role": true,
+    "aria-label": true, "aria-hidden": true, "mathvariant": true, "display": true,
+    "displaystyle": true, "fence": true, "separator": true, "stretchy": true,
+    "lspace": true, "rspace": true, "columnalign": true, "rowalign": true,
+  }
+)
+
+/**
+ * Sanitizes SVG or MathML markup embedded in HTML.
+ * Only a small allowlist of presentational elements and attributes is kept.  Event handler
+ * attributes, resource references like {@code xlink:href}, {@code <script>}, and
+ * {@code <foreignObject>} (along with everything inside it) are removed, and text is normalized
+ * so that it cannot introduce new tags.  The result can be used as
+ * {@link CONTENT_KIND_HTML} content.
+ */
+func CleanSvg(s string) string {
+  buf := bytes.NewBuffer(make([]byte, 0, len(s)))
+  dropDepth := 0
+  pos := 0
+  for pos < len(s) {
+    lt := strings.Index(s[pos:], "<")
+    if lt < 0 {
+      break
+    }
+    lt += pos
+    if dropDepth == 0 {
+      buf.WriteString(NormalizeHtml(s[pos:lt]))
+    }
+    if decl := _FOREIGN_MARKUP_DECL_RE.FindStringIndex(s[lt:]); decl != nil {
+      pos = lt + decl[1]
+      continue
+    }
+    match := _FOREIGN_TAG_RE.FindStringSubmatchIndex(s[lt:])
+    if match == nil || match[0] != 0 {
+      // A stray '<' that does not start a tag.
+      if dropDepth == 0 {
+        buf.WriteString("&lt;")
+      }
+      pos = lt + 1
+      continue
+    }
+    pos = lt + match[1]
+    isEndTag := match[3] > match[2]
+    name := s[lt+match[4]:lt+match[5]]
+    attrs := s[lt+match[6]:lt+match[7]]
+    selfClosing := match[9] > match[8]
+    lname := strings.ToLower(name)
+    if _DROPPED_FOREIGN_ELEMENTS[lname] {
+      if selfClosing {
+        continue
+      }
+      if isEndTag {
+        if dropDepth > 0 {
+          dropDepth--
+        }
+      } else {
+        dropDepth++
+      }
+      continue
+    }
+    if dropDepth > 0 || !_SAFE_FOREIGN_ELEMENTS[lname] {
+      continue
+    }
+    if isEndTag {
+      buf.WriteString("</" + name + ">")
+      continue
+    }
+    buf.WriteString("<" + name)
+    for _, attr := range _FOREIGN_ATTRIBUTE_RE.FindAllStringSubmatch(attrs, -1) {
+      attrName, attrValue := attr[1], attr[2]
+      if !_SAFE_FOREIGN_ATTRIBUTES[strings.ToLower(attrName)] {
+        continue
+      }
+      if len(attrValue) > 0 && (attrValue[0] == '"' || attrValue[0] == '\'') {
+        attrValue = attrValue[1:len(attrValue)-1]
+      }
+      if _FOREIGN_UNSAFE_VALUE_RE.MatchString(html.UnescapeString(attrValue)) {
+        continue
+      }
+      buf.WriteString(" " + attrName + "=\"" + NormalizeHtml(attrValue) + "\"")
+    }
+    if selfClosing {
+      buf.WriteString("/")
+    }
+    buf.WriteString(">")
+  }
+  if dropDepth == 0 && pos < len(s) {
+    buf.WriteString(NormalizeHtml(s[pos:]))
+  }
+  return buf.String()
+}
+
+/**
+ * Sanitizes SVG or MathML markup embedded in HTML, returning it as HTML sanitized content.
+ * @see CleanSvg
+ */
+func CleanSvgSoyData(s SoyData) *SanitizedContent {
+  if s == nil {
+    return NewSanitizedContent("", CONTENT_KIND_HTML)
+  }
+  return NewSanitizedContent(CleanSvg(s.String()), CONTENT_KIND_HTML)
+}
+
+/**
+ * The print directives that take no arguments, by name without the leading pipe.
+ */
+var _PRINT_DIRECTIVES = map[string]func(SoyData) string{
+  "escapeHtml": EscapeHtmlSoyData,
+  "escapeHtmlRcdata": EscapeHtmlRcdataSoyData,
+  "normalizeHtml": NormalizeHtmlSoyData,
+  "normalizeHtmlNospace": NormalizeHtmlNospaceSoyData,
+  "escapeHtmlAttribute": EscapeHtmlAttributeSoyData,
+  "escapeHtmlAttributeNospace": EscapeHtmlAttributeNospaceSoyData,
+  "escapeJsString": EscapeJsStringSoyData,
+  "escapeJsValue": EscapeJsValueSoyData,
+  "escapeJsRegex": EscapeJsRegexSoyData,
+  "escapeCssString": EscapeCssStringSoyData,
+  "filterCssValue": FilterCssValueSoyData,
+  "escapeUri": EscapeUriSoyData,
+  "normalizeUri": NormalizeUriSoyData,
+  "filterNormalizeUri": FilterNormalizeUriSoyData,
+  "filterNormalizeCssUri": FilterNormalizeCssUriSoyData,
+  "filterNormalizeMediaUri": FilterNormalizeMediaUriSoyData,
+  "filterImageDataUri": FilterImageDataUriSoyData,
+  "filterTrustedResourceUri": FilterTrustedResourceUriSoyData,
+  "filterHtmlAttribute": FilterHtmlAttributeSoyData,
+  "filterHtmlElementName": FilterHtmlElementNameSoyData,
+  "changeNewlineToBr": func(s SoyData) string {
+    return ChangeNewlineToBr(printDirectiveInput(s))
+  },
+  "id": printDirectiveInput,
+  "noAutoescape": printDirectiveInput,
+}
+
+func printDirectiveInput(s SoyData) string {
+  if s == nil {
+    return ""
+  }
+  return s.String()
+}
+
+/**
+ * Applies a print directive such as {@code |escapeHtml} or {@code |truncate:10,false} to input,
+ * as a template interpreter does for {@code {$x |escapeHtml}}.
+ * @param name The directive name; the leading pipe is optional.
+ * @param args The directive's arguments.  {@code |insertWordBreaks} takes the maximum number of
+ *     characters between breaks, and {@code |truncate} takes the maximum length and, optionally,
+ *     whether to add an ellipsis, which defaults to true.
+ * @return The output of the directive, or an error if the directive is unknown or its
+ *     arguments are invalid.
+ */
+func ApplyPrintDirective(name string, input SoyData, args ...SoyData) (string, error) {
+  name = strings.TrimPrefix(name, "|")
+  if fn, ok := _PRINT_DIRECTIVES[name]; ok {
+    if len(args) != 0 {
+      return "", NewSoyDataException(fmt.Sprintf("Print directive |%s takes no arguments but got %d", name, len(args)))
+    }
+    return fn(input), nil
+  }
+  switch name {
+  case "insertWordBreaks":
+    if len(args) != 1 || args[0] == nil || args[0].IntegerValue() <= 0 {
+      return "", NewSoyDataException("Print directive |insertWordBreaks takes one positive integer argument")
+    }
+    return InsertWordBreaks(printDirectiveInput(input), args[0].IntegerValue()), nil
+  case "truncate":
+    if len(args) < 1 || len(args) > 2 || args[0] == nil || args[0].IntegerValue() < 0 {
+      return "", NewSoyDataException("Print directive |truncate takes a non-negative maximum length and an optional boolean")
+    }
+    addEllipsis := true
+    if len(args) == 2 && args[1] != nil {
+      addEllipsis = args[1].Bool()
+    }
+    return Truncate(printDirectiveInput(input), args[0].IntegerValue(), addEllipsis), nil
+  }
+  return "", NewSoyDataException("Unknown print directive |" + name)
+}
@@ -2,7 +2,15 @@ package soyutil_test;
 
 import (
   . "closure/template/soyutil"
+  "bytes"
+  "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "strings"
   "testing"
+  "testing/iotest"
+  "unicode/utf8"
 )
 
 
@@ -17,3 +25,634 @@ func TestEscapeHtml(t *testing.T) {
   }
 }
 
+
+func TestCleanSvg(t *testing.T) {
+  benign := "<svg width=\"10\" height=\"10\"><circle cx=\"5\" cy=\"5\" r=\"4\" fill=\"red\"/></svg>"
+  assertStringEquals(t, benign, CleanSvg(benign), "CleanSvg should keep a benign circle")
+  assertStringEquals(t, "<svg><circle r=\"4\"/></svg>", CleanSvg("<svg onload=\"alert(1)\"><circle r=\"4\" onclick='alert(2)'/></svg>"), "CleanSvg should strip event handlers")
+  assertStringEquals(t, "<svg></svg>", CleanSvg("<svg><foreignObject><img src=x onerror=alert(1)></foreignObject></svg>"), "CleanSvg should drop foreignObject content")
+  assertStringEquals(t, "<svg></svg>", CleanSvg("<svg><script>alert(1)</script></svg>"), "CleanSvg should drop scripts")
+  assertStringEquals(t, "<svg><path d=\"M0 0\"/></svg>", CleanSvg("<svg><path xlink:href=\"javascript:alert(1)\" d=\"M0 0\"/></svg>"), "CleanSvg should strip xlink:href")
+  assertStringEquals(t, "<math><mi>x</mi><mo>&lt;</mo><mn>2</mn></math>", CleanSvg("<math><mi>x</mi><mo><</mo><mn>2</mn></math>"), "CleanSvg should keep MathML")
+  sc := CleanSvgSoyData(NewStringData("<svg onload=alert(1)></svg>"))
+  assertStringEquals(t, "<svg></svg>", sc.Content(), "CleanSvgSoyData content")
+  if sc.ContentKind() != CONTENT_KIND_HTML {
+    t.Errorf("CleanSvgSoyData should produce HTML but was %v", sc.ContentKind())
+  }
+}
+
+func TestEscapeHtmlRcdata(t *testing.T) {
+  assertStringEquals(t, "say \"hi\" &amp; &lt;b>", EscapeHtmlRcdata("say \"hi\" & <b>"), "EscapeHtmlRcdata should only escape < and &")
+  assertStringEquals(t, "say &quot;hi&quot; &amp; &lt;b&gt;", EscapeHtml("say \"hi\" & <b>"), "EscapeHtml should escape quotes")
+  assertStringEquals(t, "a \"b\" &lt;c", EscapeHtmlRcdataSoyData(NewStringData("a \"b\" <c")), "EscapeHtmlRcdataSoyData on plain text")
+  assertStringEquals(t, "&lt;b&gt;&amp;&quot;", EscapeHtmlRcdataSoyData(NewSanitizedContent("<b>&amp;\"", CONTENT_KIND_HTML)), "EscapeHtmlRcdataSoyData on HTML")
+}
+
+func TestEscapeFor(t *testing.T) {
+  esc, ok := EscapeHtmlInstance.EscapeFor('&')
+  if !ok || esc != "&amp;" {
+    t.Errorf("EscapeHtmlInstance.EscapeFor('&') -> %q, %v expected: \"&amp;\", true", esc, ok)
+  }
+  esc, ok = EscapeHtmlInstance.EscapeFor('a')
+  if ok || esc != "" {
+    t.Errorf("EscapeHtmlInstance.EscapeFor('a') -> %q, %v expected: \"\", false", esc, ok)
+  }
+  esc, ok = EscapeHtmlNospaceInstance.EscapeFor('\u2028')
+  if !ok || esc != "&#8232;" {
+    t.Errorf("EscapeHtmlNospaceInstance.EscapeFor('\\u2028') -> %q, %v expected: \"&#8232;\", true", esc, ok)
+  }
+  esc, ok = EscapeUriInstance.EscapeFor('é')
+  if !ok || esc != "%C3%A9" {
+    t.Errorf("EscapeUriInstance.EscapeFor('\\u00e9') -> %q, %v expected: \"%%C3%%A9\", true", esc, ok)
+  }
+  for _, escaper := range []CrossLanguageStringXform{EscapeHtmlInstance, EscapeJsStringInstance, EscapeUriInstance} {
+    esc, ok = escaper.EscapeFor(-1)
+    if ok || esc != "" {
+      t.Errorf("%s.EscapeFor(-1) -> %q, %v expected: \"\", false", escaper.DirectiveName(), esc, ok)
+    }
+  }
+  esc, ok = EscapeHtmlInstance.EscapeFor(utf8.RuneError)
+  if ok || esc != "" {
+    t.Errorf("EscapeHtmlInstance.EscapeFor(utf8.RuneError) -> %q, %v expected: \"\", false", esc, ok)
+  }
+  esc, ok = EscapeUriInstance.EscapeFor(utf8.RuneError)
+  if !ok || esc != "%EF%BF%BD" {
+    t.Errorf("EscapeUriInstance.EscapeFor(utf8.RuneError) -> %q, %v expected: \"%%EF%%BF%%BD\", true", esc, ok)
+  }
+}
+
+func TestFilterNormalizeCssUri(t *testing.T) {
+  assertStringEquals(t, "images/bg.png", FilterNormalizeCssUri("images/bg.png"), "FilterNormalizeCssUri on a relative path")
+  assertStringEquals(t, "#zSoyz", FilterNormalizeCssUri("javascript:alert(1)"), "FilterNormalizeCssUri on javascript:")
+  assertStringEquals(t, "a%29%20b%27%22%5C.png", FilterNormalizeCssUri("a) b'\"\\.png"), "FilterNormalizeCssUri on CSS delimiters")
+}
+
+func TestSafePreview(t *testing.T) {
+  assertStringEquals(t, "abcd&lt;...", SafePreview("abcd<efgh", 8).Content(), "SafePreview keeps a whole entity")
+  assertStringEquals(t, "abc...", SafePreview("abc<defgh", 6).Content(), "SafePreview cuts before an entity")
+  assertStringEquals(t, "1 &lt; 2", SafePreview("1 < 2", 5).Content(), "SafePreview when it fits")
+  if SafePreview("<b>", 2).ContentKind() != CONTENT_KIND_HTML {
+    t.Errorf("SafePreview should produce HTML")
+  }
+}
+
+type failingWriter struct {}
+
+func (p failingWriter) Write(b []byte) (int, error) {
+  return 0, errors.New("write failed")
+}
+
+func TestEscapeErr(t *testing.T) {
+  s, err := EscapeHtmlErr("1 < 2")
+  if err != nil || s != "1 &lt; 2" {
+    t.Errorf("EscapeHtmlErr(\"1 < 2\") -> %q, %v expected: \"1 &lt; 2\", nil", s, err)
+  }
+  s, err = EscapeJsStringErr("it's")
+  if err != nil || s != "it\\x27s" {
+    t.Errorf("EscapeJsStringErr(\"it's\") -> %q, %v", s, err)
+  }
+  _, err = io.WriteString(EscapeHtmlInstance.EscapedWriter(failingWriter{}), "1 < 2")
+  if err == nil || err.Error() != "write failed" {
+    t.Errorf("EscapedWriter should propagate the writer error but got: %v", err)
+  }
+}
+
+func TestFilterNormalizeUriFullWidth(t *testing.T) {
+  assertStringEquals(t, "#zSoyz", FilterNormalizeUri("ｊａｖａｓｃｒｉｐｔ：alert(1)"), "FilterNormalizeUri with a full-width javascript: scheme")
+  assertStringEquals(t, "#zSoyz", FilterNormalizeUri("javascript：alert(1)"), "FilterNormalizeUri with a full-width colon after javascript")
+  assertStringEquals(t, "http：//example.com/", FilterNormalizeUri("http：//example.com/"), "FilterNormalizeUri with a full-width colon after http")
+  assertStringEquals(t, "#zSoyz", FilterNormalizeCssUri("ｊａｖａｓｃｒｉｐｔ：alert(1)"), "FilterNormalizeCssUri with a full-width javascript: scheme")
+}
+
+func TestBuildInlineStyle(t *testing.T) {
+  style := BuildInlineStyle(map[string]string{
+    "color": "red",
+    "border": "1px solid #ccc",
+    "width": "expression(alert(1))",
+    "bad name;": "blue",
+  })
+  assertStringEquals(t, "style=\"border: 1px solid #ccc; color: red\"", style.Content(), "BuildInlineStyle")
+  if style.ContentKind() != CONTENT_KIND_HTML_ATTRIBUTE {
+    t.Errorf("BuildInlineStyle should produce an HTML attribute but was %v", style.ContentKind())
+  }
+  assertStringEquals(t, "style=\"\"", BuildInlineStyle(map[string]string{"background": "url(javascript:alert(1))"}).Content(), "BuildInlineStyle with only unsafe values")
+}
+
+func TestBuildAttributes(t *testing.T) {
+  attrs := BuildAttributes(NewSoyMapDataFromArgs(
+    "href", "/search?q=a b&x=\"y\"",
+    "style", "color: red; width: expression(alert(1)); margin: 0 auto",
+    "onclick", "alert(1)",
+    "title", "Tom & \"Jerry\"",
+  ))
+  assertStringEquals(t, "href=\"/search?q=a%20b&amp;x=%22y%22\" style=\"color: red; margin: 0 auto\" title=\"Tom &amp; &quot;Jerry&quot;\"", attrs.Content(), "BuildAttributes")
+  if attrs.ContentKind() != CONTENT_KIND_HTML_ATTRIBUTE {
+    t.Errorf("BuildAttributes should produce HTML attributes but was %v", attrs.ContentKind())
+  }
+  assertStringEquals(t, "src=\"#zSoyz\"", BuildAttributes(NewSoyMapDataFromArgs("src", "javascript:alert(1)")).Content(), "BuildAttributes with an unsafe URI")
+  assertStringEquals(t, "", BuildAttributes(NewSoyMapDataFromArgs("onclick", "alert(1)", "ONLOAD", "x")).Content(), "BuildAttributes with only event handlers")
+  assertStringEquals(t, "", BuildAttributes(nil).Content(), "BuildAttributes(nil)")
+  assertStringEquals(t, "formaction=\"#zSoyz\" xlink:href=\"#zSoyz\"", BuildAttributes(NewSoyMapDataFromArgs(
+    "formaction", "javascript:alert(1)",
+    "xlink:href", "javascript:x",
+  )).Content(), "BuildAttributes with unsafe URIs in other URI attributes")
+  assertStringEquals(t, "action=\"/submit\" poster=\"/a.png\" profileUrl=\"#zSoyz\"", BuildAttributes(NewSoyMapDataFromArgs(
+    "action", "/submit",
+    "poster", "/a.png",
+    "profileUrl", "javascript:x",
+  )).Content(), "BuildAttributes with URI attributes")
+  assertStringEquals(t, "srcset=\"/a.png 1x, #zSoyz 2x, /c.png\"", BuildAttributes(NewSoyMapDataFromArgs(
+    "srcset", "/a.png 1x, javascript:x 2x, /c.png onerror=x",
+  )).Content(), "BuildAttributes with a srcset")
+  assertStringEquals(t, "title=\"y\"", BuildAttributes(NewSoyMapDataFromArgs("", "x", "a b", "x", "title", "y")).Content(), "BuildAttributes with empty and invalid names")
+}
+
+func TestWillEscape(t *testing.T) {
+  assertBoolEquals(t, false, EscapeHtmlInstance.WillEscape("plain text"), "WillEscape on safe text")
+  assertBoolEquals(t, true, EscapeHtmlInstance.WillEscape("a < b"), "WillEscape on unsafe text")
+  assertBoolEquals(t, true, EscapeUriInstance.WillEscape("é"), "WillEscape on a prefix escaped char")
+  for _, s := range []string{"", "already safe html", "1 < 2 & 3", "it's \"quoted\""} {
+    assertStringEquals(t, EscapeHtml(s), EscapeHtmlSoyData(NewStringData(s)), "EscapeHtmlSoyData should match EscapeHtml")
+  }
+}
+
+func BenchmarkEscapeHtmlSoyDataSafe(b *testing.B) {
+  s := NewStringData("This is some already safe HTML text without any special characters in it")
+  for i := 0; i < b.N; i++ {
+    EscapeHtmlSoyData(s)
+  }
+}
+
+/**
+ * A mix of inputs with many, few and no characters to escape.
+ */
+var escapeBenchmarkInputs = []string{
+  "<a href=\"/search?q=a&b=c\">O'Reilly & Sons <b>bold</b></a>",
+  "This is some already safe HTML text without any special characters in it",
+  "Tom & Jerry",
+  "short",
+  "<<<<>>>>&&&&\"\"\"\"''''",
+}
+
+func BenchmarkEscapeHtmlMixed(b *testing.B) {
+  b.ReportAllocs()
+  for i := 0; i < b.N; i++ {
+    EscapeHtml(escapeBenchmarkInputs[i % len(escapeBenchmarkInputs)])
+  }
+}
+
+func BenchmarkEscapeJsStringMixed(b *testing.B) {
+  b.ReportAllocs()
+  for i := 0; i < b.N; i++ {
+    EscapeJsString(escapeBenchmarkInputs[i % len(escapeBenchmarkInputs)])
+  }
+}
+
+/**
+ * Escapes onto a new buffer for every input that needs escaping, as Escape did before it
+ * reused pooled buffers, to give the Mixed benchmarks a baseline.
+ */
+func benchmarkEscapeUnpooled(b *testing.B, escaper CrossLanguageStringXform) {
+  b.ReportAllocs()
+  for i := 0; i < b.N; i++ {
+    s := escapeBenchmarkInputs[i % len(escapeBenchmarkInputs)]
+    if escaper.WillEscape(s) {
+      buf := bytes.NewBuffer(make([]byte, 0, len(s) + 32))
+      io.WriteString(escaper.EscapedWriter(buf), s)
+      _ = buf.String()
+    }
+  }
+}
+
+func BenchmarkEscapeHtmlMixedUnpooled(b *testing.B) {
+  benchmarkEscapeUnpooled(b, EscapeHtmlInstance)
+}
+
+func BenchmarkEscapeJsStringMixedUnpooled(b *testing.B) {
+  benchmarkEscapeUnpooled(b, EscapeJsStringInstance)
+}
+
+func TestEscapeUriComponentSoyData(t *testing.T) {
+  uri := NewSanitizedContent("http://example.com/?q=1&r=2", CONTENT_KIND_URI)
+  assertStringEquals(t, "http://example.com/?q=1&r=2", EscapeUriSoyData(uri), "EscapeUriSoyData normalizes trusted URIs for an href")
+  assertStringEquals(t, "http%3A%2F%2Fexample.com%2F%3Fq%3D1%26r%3D2", EscapeUriComponentSoyData(uri), "EscapeUriComponentSoyData encodes trusted URIs for a query param")
+  assertStringEquals(t, "a%26b", EscapeUriComponentSoyData(NewStringData("a&b")), "EscapeUriComponentSoyData on plain text")
+  assertStringEquals(t, "", EscapeUriComponentSoyData(NilDataInstance), "EscapeUriComponentSoyData on null")
+}
+
+func TestFiltersRejectNul(t *testing.T) {
+  assertStringEquals(t, "zSoyz", FilterCssValue("red\x00"), "FilterCssValue with NUL")
+  assertStringEquals(t, "#zSoyz", FilterNormalizeUri("/foo\x00bar"), "FilterNormalizeUri with NUL")
+  assertStringEquals(t, "#zSoyz", FilterNormalizeCssUri("/foo\x00bar"), "FilterNormalizeCssUri with NUL")
+  assertStringEquals(t, "zSoyz", FilterHtmlAttribute("title\x00"), "FilterHtmlAttribute with NUL")
+  assertStringEquals(t, "zSoyz", FilterHtmlElementName("div\x00"), "FilterHtmlElementName with NUL")
+  for _, escaper := range AllEscapers() {
+    if escaper.ValueFilter() == nil && !escaper.WillEscape("\x00") {
+      t.Errorf("%s does not escape NUL", escaper.DirectiveName())
+    }
+  }
+  assertStringEquals(t, "a&#0;b", EscapeHtmlRcdata("a\x00b"), "EscapeHtmlRcdata with NUL")
+}
+
+func TestEscapeHtmlWithAposEntity(t *testing.T) {
+  assertStringEquals(t, "O&#39;Reilly &amp; Sons", EscapeHtml("O'Reilly & Sons"), "EscapeHtml apostrophe")
+  assertStringEquals(t, "O&#39;Reilly &amp; Sons", EscapeHtmlWithAposEntity("O'Reilly & Sons", false), "EscapeHtmlWithAposEntity(false)")
+  assertStringEquals(t, "O&apos;Reilly &amp; Sons", EscapeHtmlWithAposEntity("O'Reilly & Sons", true), "EscapeHtmlWithAposEntity(true)")
+}
+
+func TestEscapeJsonInHtml(t *testing.T) {
+  sc := EscapeJsonInHtml(NewStringData("</script>&\u2028"))
+  assertStringEquals(t, `"\u003c/script\u003e\u0026\u2028"`, sc.String(), "EscapeJsonInHtml string")
+  assertBoolEquals(t, true, sc.ContentKind() == CONTENT_KIND_HTML, "EscapeJsonInHtml content kind")
+  assertStringEquals(t, `{"a":"\u003c/script\u003e","b":2}`, EscapeJsonInHtml(NewSoyMapDataFromArgs("b", 2, "a", "</script>")).String(), "EscapeJsonInHtml map")
+  assertStringEquals(t, "null", EscapeJsonInHtml(NilDataInstance).String(), "EscapeJsonInHtml nil")
+}
+
+func TestEscapeUri(t *testing.T) {
+  assertStringEquals(t, "a%20b", EscapeUri("a b"), "EscapeUri encodes a space as %20")
+  assertStringEquals(t, "a%2Bb", EscapeUri("a+b"), "EscapeUri encodes a plus")
+  assertStringEquals(t, "AZaz09-._~", EscapeUri("AZaz09-._~"), "EscapeUri leaves unreserved characters")
+  assertStringEquals(t, "%3F%26%3D%23%27%28%29%2A%21", EscapeUri("?&=#'()*!"), "EscapeUri encodes delimiters")
+  assertStringEquals(t, "caf%C3%A9", EscapeUri("caf\u00e9"), "EscapeUri encodes UTF-8")
+  assertStringEquals(t, "a%20b", EscapeUriSoyData(NewStringData("a b")), "EscapeUriSoyData encodes a space as %20")
+  value, escaped := EscapeUriCounted("abc")
+  assertStringEquals(t, "abc", value, "EscapeUriCounted of safe text")
+  assertBoolEquals(t, false, escaped, "EscapeUriCounted of safe text escaped")
+}
+
+func TestEscapeUriPath(t *testing.T) {
+  assertStringEquals(t, "a%2Fb%20c", EscapeUri("a/b c"), "EscapeUri encodes the slash")
+  assertStringEquals(t, "a/b%20c", EscapeUriPath("a/b c"), "EscapeUriPath preserves the slash")
+  assertStringEquals(t, "users/j@x;v=1/caf%C3%A9%22%27%28%29", EscapeUriPath("users/j@x;v=1/café\"'()"), "EscapeUriPath with sub-delimiters")
+}
+
+func TestFilterHtmlAttributeSoyDataQuotesValue(t *testing.T) {
+  assertStringEquals(t, "dir=\"ltr\"", FilterHtmlAttributeSoyData(NewSanitizedContent("dir=ltr", CONTENT_KIND_HTML_ATTRIBUTE)), "unquoted attribute value")
+  assertStringEquals(t, "title=\"foo&quot;bar\"", FilterHtmlAttributeSoyData(NewSanitizedContent("title=foo\"bar", CONTENT_KIND_HTML_ATTRIBUTE)), "unquoted attribute value containing a quote")
+}
+
+func TestEscapeJsStringJson(t *testing.T) {
+  assertStringEquals(t, "a\\x0bb\\x27", EscapeJsString("a\x0bb'"), "EscapeJsString uses \\x escapes")
+  assertStringEquals(t, "a\\u000bb\\u0027", EscapeJsStringJson("a\x0bb'"), "EscapeJsStringJson uses \\u escapes")
+  assertStringEquals(t, "\\n\\u003c\\/", EscapeJsStringJson("\n</"), "EscapeJsStringJson keeps the other escapes")
+}
+
+func TestEscapeCssStringCommentDelimiters(t *testing.T) {
+  assertStringEquals(t, "\\2f \\2a ", EscapeCssString("/*"), "EscapeCssString comment opener")
+  assertStringEquals(t, "\\2a \\2f ", EscapeCssString("*/"), "EscapeCssString comment closer")
+  assertStringEquals(t, "\\3c \\2f style\\3e ", EscapeCssString("</style>"), "EscapeCssString end tag")
+  // The space ends each escape, so a following hex digit or space is not swallowed by it.
+  assertStringEquals(t, "\\2f a\\2a  b", EscapeCssString("/a* b"), "EscapeCssString escapes followed by a hex digit and a space")
+  for _, s := range []string{"/*", "*/", "a/**/b", "\\2f*", "</style>"} {
+    escaped := EscapeCssString(s)
+    if strings.Contains(escaped, "/*") || strings.Contains(escaped, "*/") || strings.Contains(escaped, "</") {
+      t.Errorf("EscapeCssString(%q) = %q contains a comment delimiter or end tag", s, escaped)
+    }
+  }
+}
+
+func TestBuildDataAttribute(t *testing.T) {
+  sc := BuildDataAttribute("config", NewSoyMapDataFromArgs("ids", NewSoyListDataFromArgs(1, 2), "title", "it's \"x\""))
+  assertStringEquals(t, "data-config=\"{&quot;ids&quot;:[1,2],&quot;title&quot;:&quot;it&#39;s \\&quot;x\\&quot;&quot;}\"", sc.String(), "BuildDataAttribute with a nested value")
+  assertBoolEquals(t, true, sc.ContentKind() == CONTENT_KIND_HTML_ATTRIBUTE, "BuildDataAttribute content kind")
+  assertStringEquals(t, "zSoyz", BuildDataAttribute("my config", NewIntegerData(1)).String(), "BuildDataAttribute with a space in the name")
+  assertStringEquals(t, "zSoyz", BuildDataAttribute("x\" onclick=\"y", NewIntegerData(1)).String(), "BuildDataAttribute with a quote in the name")
+  assertStringEquals(t, "data-n=\"null\"", BuildDataAttribute("n", nil).String(), "BuildDataAttribute with a nil value")
+}
+
+func escapeWith(escaper Escaper, s string) (string, string) {
+  escaped, _ := escaper.Escape(s)
+  var buf bytes.Buffer
+  io.WriteString(escaper.EscapedWriter(&buf), s)
+  return escaped, buf.String()
+}
+
+func TestEscaperInterface(t *testing.T) {
+  escaped, written := escapeWith(EscapeHtmlInstance, "a < b")
+  assertStringEquals(t, "a &lt; b", escaped, "Escaper.Escape")
+  assertStringEquals(t, "a &lt; b", written, "Escaper.EscapedWriter")
+}
+
+func TestToPlainText(t *testing.T) {
+  assertStringEquals(t, "a & b", ToPlainText("<b>a &amp; b</b>"), "ToPlainText with an entity")
+  assertStringEquals(t, "1 < 2 \"quoted\"", ToPlainText("<p class=\"x>y\">1 &lt; 2</p> <i>&quot;quoted&#34;</i>"), "ToPlainText with several tags")
+  assertStringEquals(t, "plain", ToPlainText("plain"), "ToPlainText without markup")
+}
+
+func TestBuildScriptTag(t *testing.T) {
+  sc := BuildScriptTag(NewStringData("var s = '</script><script>alert(1)</SCRIPT>'; // <!-- x"), "rAnd0m+/Nonce==")
+  assertStringEquals(t, "<script nonce=\"rAnd0m+/Nonce==\">var s = '<\\/script><script>alert(1)<\\/SCRIPT>'; // <!-- x</script>", sc.String(), "BuildScriptTag with a valid nonce")
+  assertBoolEquals(t, true, sc.ContentKind() == CONTENT_KIND_HTML, "BuildScriptTag content kind")
+  assertStringEquals(t, "zSoyz", BuildScriptTag(NewStringData("f()"), "a\" onload=\"x").String(), "BuildScriptTag with an invalid nonce")
+  assertStringEquals(t, "zSoyz", BuildScriptTag(NewStringData("f()"), "").String(), "BuildScriptTag with an empty nonce")
+  assertStringEquals(t, "<script nonce=\"abc\"></script>", BuildScriptTag(nil, "abc").String(), "BuildScriptTag with no script")
+}
+
+func TestEscapeNonAsciiSparseMap(t *testing.T) {
+  escape := func(x CrossLanguageStringXform, s string) string {
+    out, err := x.Escape(s)
+    if err != nil {
+      t.Errorf("%s.Escape(%q) failed: %v", x.DirectiveName(), s, err)
+    }
+    return out
+  }
+  // EscapeUri has no sparse entries, so all non-ASCII characters use the %-prefix fallback.
+  assertStringEquals(t, "caf%C3%A9", escape(EscapeUriInstance, "caf\u00e9"), "EscapeUri of a 2 byte character")
+  assertStringEquals(t, "%E2%80%A8x", escape(EscapeUriInstance, "\u2028x"), "EscapeUri of a 3 byte character")
+  assertStringEquals(t, "a%20%E2%82%AC%20b", escape(EscapeUriInstance, "a \u20ac b"), "EscapeUri keeps the text around a multi-byte character")
+  // NormalizeUri has sparse entries for U+0085, U+00A0, U+2028 and U+2029 but no prefix, so
+  // other non-ASCII characters pass through unchanged.
+  assertStringEquals(t, "a%E2%80%A8b", escape(NormalizeUriInstance, "a\u2028b"), "NormalizeUri of a character in the sparse map")
+  assertStringEquals(t, "caf\u00e9", escape(NormalizeUriInstance, "caf\u00e9"), "NormalizeUri of a character not in the sparse map")
+  assertStringEquals(t, "\u00e9%C2%A0\u00e9", escape(NormalizeUriInstance, "\u00e9\u00a0\u00e9"), "NormalizeUri of mixed characters")
+  assertStringEquals(t, "caf\u00e9", EscapeHtml("caf\u00e9"), "EscapeHtml passes non-ASCII characters through")
+}
+
+func TestValueFilters(t *testing.T) {
+  assertStringEquals(t, "h1", FilterHtmlElementName("h1"), "FilterHtmlElementName(\"h1\")")
+  assertStringEquals(t, "div", FilterHtmlElementName("div"), "FilterHtmlElementName(\"div\")")
+  assertStringEquals(t, "DIV", FilterHtmlElementName("DIV"), "FilterHtmlElementName is case insensitive")
+  for _, s := range []string{"script", "SCRIPT", "style", "textarea", "noscript", "div><script", "h1/i"} {
+    assertStringEquals(t, INNOCUOUS_OUTPUT, FilterHtmlElementName(s), fmt.Sprintf("FilterHtmlElementName(%q)", s))
+  }
+
+  assertStringEquals(t, "title", FilterHtmlAttribute("title"), "FilterHtmlAttribute(\"title\")")
+  assertStringEquals(t, "aria-label", FilterHtmlAttribute("aria-label"), "FilterHtmlAttribute(\"aria-label\")")
+  for _, s := range []string{"onclick", "OnLoad", "href", "SRC", "style", "data-x", "title=x", "title\n"} {
+    assertStringEquals(t, INNOCUOUS_OUTPUT, FilterHtmlAttribute(s), fmt.Sprintf("FilterHtmlAttribute(%q)", s))
+  }
+
+  assertStringEquals(t, "color", FilterCssValue("color"), "FilterCssValue(\"color\")")
+  for _, s := range []string{"#fff", "10px", "-1.5em", "50%", "!important", ".cls", ""} {
+    assertStringEquals(t, s, FilterCssValue(s), fmt.Sprintf("FilterCssValue(%q)", s))
+  }
+  for _, s := range []string{"expression", "EXPRESSION", "--expression", "binding", "-moz-binding", "red;x:y", "url(x)"} {
+    assertStringEquals(t, INNOCUOUS_OUTPUT, FilterCssValue(s), fmt.Sprintf("FilterCssValue(%q)", s))
+  }
+
+  for _, s := range []string{"http://example.com/", "HTTPS://example.com/", "mailto:a@example.com", "/path", "page.html", "?q=1", "#top", ""} {
+    assertStringEquals(t, s, FilterNormalizeUri(s), fmt.Sprintf("FilterNormalizeUri(%q)", s))
+  }
+  for _, s := range []string{"javascript:alert(1)", "JavaScript:alert(1)", "data:text/html,x", "vbscript:x"} {
+    assertStringEquals(t, "#" + INNOCUOUS_OUTPUT, FilterNormalizeUri(s), fmt.Sprintf("FilterNormalizeUri(%q)", s))
+  }
+}
+
+func TestFilterCssValueWithVars(t *testing.T) {
+  for _, s := range []string{"--main-bg", "var(--main-bg)", "var( --main-bg )", "var(--main-bg, #fff)", "var(--a, 1px solid red)", "var(--a, var(--b, red))", "color", "10px"} {
+    assertStringEquals(t, s, FilterCssValueWithVars(s), fmt.Sprintf("FilterCssValueWithVars(%q)", s))
+  }
+  for _, s := range []string{"expression(alert(1))", "var(--a, expression(alert(1)))", "var(--a, url(javascript:x))", "var(--a, -moz-binding)", "var(--expression)", "var(--a", "var(main-bg)", "url(x)"} {
+    assertStringEquals(t, INNOCUOUS_OUTPUT, FilterCssValueWithVars(s), fmt.Sprintf("FilterCssValueWithVars(%q)", s))
+  }
+  assertStringEquals(t, INNOCUOUS_OUTPUT, FilterCssValue("var(--main-bg)"), "FilterCssValue still rejects var()")
+  assertStringEquals(t, "", FilterCssValueWithVarsSoyData(nil), "FilterCssValueWithVarsSoyData(nil)")
+  assertStringEquals(t, "", FilterCssValueWithVarsSoyData(NilDataInstance), "FilterCssValueWithVarsSoyData(NilDataInstance)")
+}
+
+func TestEscaperByName(t *testing.T) {
+  escaper, ok := EscaperByName("escapeHtml")
+  assertBoolEquals(t, true, ok, "EscaperByName(\"escapeHtml\") found")
+  if escaper != EscapeHtmlInstance {
+    t.Errorf("EscaperByName(\"escapeHtml\") should be EscapeHtmlInstance but was %v", escaper)
+  }
+  escaper, ok = EscaperByName("|escapeJsString")
+  assertBoolEquals(t, true, ok, "EscaperByName(\"|escapeJsString\") found")
+  if escaper != EscapeJsStringInstance {
+    t.Errorf("EscaperByName(\"|escapeJsString\") should be EscapeJsStringInstance but was %v", escaper)
+  }
+  escaper, ok = EscaperByName("noSuchDirective")
+  assertBoolEquals(t, false, ok, "EscaperByName(\"noSuchDirective\") found")
+  if escaper != nil {
+    t.Errorf("EscaperByName(\"noSuchDirective\") should be nil but was %v", escaper)
+  }
+  escaper, _ = EscaperByName("escapeJsRegex")
+  if escaper != EscapeJsRegexInstance {
+    t.Errorf("EscaperByName(\"escapeJsRegex\") should be EscapeJsRegexInstance but was %v", escaper)
+  }
+  for name, expected := range map[string]CrossLanguageStringXform{
+    "escapeHtmlApos": EscapeHtmlAposInstance,
+    "escapeHtmlEscapeSlash": EscapeHtmlEscapeSlashInstance,
+    "escapeJsStringJson": EscapeJsStringJsonInstance,
+  } {
+    if escaper, _ = EscaperByName(name); escaper != expected {
+      t.Errorf("EscaperByName(%q) should be %v but was %v", name, expected, escaper)
+    }
+  }
+  for _, x := range AllEscapers() {
+    found, _ := EscaperByName(x.DirectiveName())
+    if found != x {
+      t.Errorf("EscaperByName(%q) did not return its escaper", x.DirectiveName())
+    }
+  }
+}
+
+func TestApplyPrintDirective(t *testing.T) {
+  apply := func(name string, input SoyData, args ...SoyData) string {
+    out, err := ApplyPrintDirective(name, input, args...)
+    if err != nil {
+      t.Errorf("ApplyPrintDirective(%q) failed: %v", name, err)
+    }
+    return out
+  }
+  input := NewStringData("<a href=\"x\">O'Reilly & co</a>")
+  assertStringEquals(t, EscapeHtmlSoyData(input), apply("|escapeHtml", input), "|escapeHtml")
+  assertStringEquals(t, EscapeHtmlSoyData(input), apply("escapeHtml", input), "escapeHtml without a pipe")
+  assertStringEquals(t, EscapeJsStringSoyData(input), apply("|escapeJsString", input), "|escapeJsString")
+  assertStringEquals(t, EscapeUriSoyData(input), apply("|escapeUri", input), "|escapeUri")
+  assertStringEquals(t, FilterNormalizeUriSoyData(NewStringData("javascript:x")), apply("|filterNormalizeUri", NewStringData("javascript:x")), "|filterNormalizeUri")
+  assertStringEquals(t, "a<br/>b", apply("|changeNewlineToBr", NewStringData("a\nb")), "|changeNewlineToBr")
+  assertStringEquals(t, InsertWordBreaks("abcdefghij", 3), apply("|insertWordBreaks", NewStringData("abcdefghij"), NewIntegerData(3)), "|insertWordBreaks")
+  assertStringEquals(t, "abcde...", apply("|truncate", NewStringData("abcdefghijkl"), NewIntegerData(8)), "|truncate")
+  assertStringEquals(t, "abcdefgh", apply("|truncate", NewStringData("abcdefghijkl"), NewIntegerData(8), NewBooleanData(false)), "|truncate without an ellipsis")
+  assertStringEquals(t, "", apply("|escapeHtml", nil), "|escapeHtml of nil")
+
+  for _, bad := range []struct {
+    name string
+    args []SoyData
+  }{
+    {"|noSuchDirective", nil},
+    {"|escapeHtml", []SoyData{NewIntegerData(1)}},
+    {"|insertWordBreaks", nil},
+    {"|insertWordBreaks", []SoyData{NewIntegerData(0)}},
+    {"|truncate", nil},
+  } {
+    if _, err := ApplyPrintDirective(bad.name, input, bad.args...); err == nil {
+      t.Errorf("ApplyPrintDirective(%q, %v) should fail", bad.name, bad.args)
+    }
+  }
+}
+
+func TestEscapeCounted(t *testing.T) {
+  value, escaped := EscapeHtmlCounted("plain text")
+  assertStringEquals(t, "plain text", value, "EscapeHtmlCounted of safe text")
+  assertBoolEquals(t, false, escaped, "EscapeHtmlCounted of safe text escaped")
+  value, escaped = EscapeHtmlCounted("1 < 2")
+  assertStringEquals(t, "1 &lt; 2", value, "EscapeHtmlCounted of unsafe text")
+  assertBoolEquals(t, true, escaped, "EscapeHtmlCounted of unsafe text escaped")
+  _, escaped = EscapeHtmlCounted("")
+  assertBoolEquals(t, false, escaped, "EscapeHtmlCounted of the empty string escaped")
+
+  _, escaped = EscapeJsStringCounted("it's")
+  assertBoolEquals(t, true, escaped, "EscapeJsStringCounted of a quote escaped")
+  _, escaped = EscapeJsStringCounted("its")
+  assertBoolEquals(t, false, escaped, "EscapeJsStringCounted of safe text escaped")
+  _, escaped = EscapeCssStringCounted("a\"b")
+  assertBoolEquals(t, true, escaped, "EscapeCssStringCounted of a quote escaped")
+  _, escaped = NormalizeUriCounted("/a/b?c=d")
+  assertBoolEquals(t, false, escaped, "NormalizeUriCounted of a normal URI escaped")
+  value, escaped = NormalizeUriCounted("/a b")
+  assertStringEquals(t, "/a%20b", value, "NormalizeUriCounted of a space")
+  assertBoolEquals(t, true, escaped, "NormalizeUriCounted of a space escaped")
+
+  for _, s := range []string{"", "safe", "<b>", "a & b", "caf\u00e9"} {
+    value, escaped := EscapeHtmlCounted(s)
+    assertStringEquals(t, EscapeHtml(s), value, "EscapeHtmlCounted should match EscapeHtml")
+    assertBoolEquals(t, EscapeHtmlInstance.WillEscape(s), escaped, "EscapeHtmlCounted should agree with WillEscape")
+  }
+}
+
+func TestEscapeHtmlAttributeTags(t *testing.T) {
+  assertStringEquals(t, "&lt;b&gt;bold&lt;/b&gt;", EscapeHtmlAttribute("<b>bold</b>"), "EscapeHtmlAttribute escapes tags in plain text")
+  assertStringEquals(t, "&lt;b&gt;bold&lt;/b&gt;", EscapeHtmlAttributeSoyData(NewStringData("<b>bold</b>")), "EscapeHtmlAttributeSoyData escapes tags in plain text")
+  assertStringEquals(t, "bold", EscapeHtmlAttributeStripping("<b>bold</b>"), "EscapeHtmlAttributeStripping strips tags")
+  assertStringEquals(t, "a &amp; &quot;b&quot;", EscapeHtmlAttributeStripping("<b>a &amp; \"b\"</b>"), "EscapeHtmlAttributeStripping keeps entities and escapes quotes")
+  assertStringEquals(t, "bold", EscapeHtmlAttributeSoyData(NewSanitizedContent("<b>bold</b>", CONTENT_KIND_HTML)), "EscapeHtmlAttributeSoyData strips tags from HTML")
+  assertStringEquals(t, "a &amp; b c", StripHtmlTags("<b>a &amp; b</b> <i>c</i>", true), "StripHtmlTags with several tags")
+}
+
+func TestFilterNormalizeMediaUri(t *testing.T) {
+  for _, s := range []string{
+    "data:image/png;base64,iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg==",
+    "https://example.com/cat.jpg",
+    "http://example.com/a.mp4",
+    "ftp://example.com/a.ogg",
+    "/images/cat.gif",
+    "cat.gif",
+  } {
+    assertStringEquals(t, s, FilterNormalizeMediaUri(s), fmt.Sprintf("FilterNormalizeMediaUri(%q)", s))
+  }
+  assertStringEquals(t, "/a%20b.png", FilterNormalizeMediaUri("/a b.png"), "FilterNormalizeMediaUri normalizes")
+  for _, s := range []string{
+    "javascript:alert(1)",
+    "JAVASCRIPT:alert(1)",
+    "data:text/html;base64,PHNjcmlwdD4=",
+    "data:image/svg+xml,<svg onload=alert(1)>",
+    "data:image/png;base64,not base64!",
+    "\uFF4A\uFF41\uFF56\uFF41\uFF53\uFF43\uFF52\uFF49\uFF50\uFF54\uFF1Aalert(1)",
+    "\uFF2A\uFF21\uFF36\uFF21\uFF33\uFF23\uFF32\uFF29\uFF30\uFF34\uFF1Aalert(1)",
+    "data\uFF1Atext/html;base64,PHNjcmlwdD4=",
+  } {
+    assertStringEquals(t, "about:invalid#zSoyz", FilterNormalizeMediaUri(s), fmt.Sprintf("FilterNormalizeMediaUri(%q)", s))
+  }
+  assertStringEquals(t, "https://example.com/", FilterNormalizeMediaUriSoyData(NewStringData("https://example.com/")), "FilterNormalizeMediaUriSoyData")
+  assertStringEquals(t, "", FilterNormalizeMediaUriSoyData(NilDataInstance), "FilterNormalizeMediaUriSoyData(NilData)")
+  x, ok := EscaperByName("filterNormalizeMediaUri")
+  if !ok || x != FilterNormalizeMediaUriInstance {
+    t.Errorf("EscaperByName(\"filterNormalizeMediaUri\") should find FilterNormalizeMediaUriInstance")
+  }
+}
+
+func TestFilterImageDataUri(t *testing.T) {
+  for _, subtype := range []string{"gif", "png", "jpeg", "webp", "bmp", "PNG"} {
+    s := "data:image/" + subtype + ";base64,R0lGODlhAQABAAAAACw="
+    assertStringEquals(t, s, FilterImageDataUri(s), fmt.Sprintf("FilterImageDataUri of %s", subtype))
+  }
+  for _, s := range []string{
+    "data:image/png;base64,R0lGODlhAQABAAAAACw",
+    "data:image/png;base64,R0lG ODlh",
+    "data:image/png;base64,R0lG=ODlh",
+    "data:image/png;base64,",
+    "data:image/svg+xml;base64,PHN2Zz4=",
+    "data:text/html;base64,PHNjcmlwdD4=",
+    "data:image/png,R0lGODlh",
+    "https://example.com/a.png",
+    "javascript:alert(1)",
+  } {
+    assertStringEquals(t, INNOCUOUS_OUTPUT, FilterImageDataUri(s), fmt.Sprintf("FilterImageDataUri(%q)", s))
+  }
+  uri := NewSanitizedContent("https://example.com/a.png", CONTENT_KIND_URI)
+  assertStringEquals(t, "https://example.com/a.png", FilterImageDataUriSoyData(uri), "FilterImageDataUriSoyData of URI content")
+  assertStringEquals(t, INNOCUOUS_OUTPUT, FilterImageDataUriSoyData(NewStringData("https://example.com/a.png")), "FilterImageDataUriSoyData of a string")
+  assertStringEquals(t, "", FilterImageDataUriSoyData(nil), "FilterImageDataUriSoyData(nil)")
+}
+
+func TestFilterTrustedResourceUri(t *testing.T) {
+  for _, s := range []string{
+    "https://cdn.example.com/js/app.js",
+    "HTTPS://cdn.example.com",
+    "https://cdn.example.com:8443/a.js?v=1#x",
+    "//cdn.example.com/js/app.js",
+    "/js/app.js",
+    "/",
+  } {
+    assertStringEquals(t, s, FilterTrustedResourceUri(s), fmt.Sprintf("FilterTrustedResourceUri(%q)", s))
+  }
+  for _, s := range []string{
+    "http://cdn.example.com/js/app.js",
+    "js/app.js",
+    "../js/app.js",
+    "javascript:alert(1)",
+    "data:text/javascript,alert(1)",
+    "https:///a.js",
+    "///evil.com/a.js",
+    "/\\evil.com/a.js",
+    "https://cdn.example.com/a b.js",
+    "",
+  } {
+    assertStringEquals(t, "about:invalid#zSoyz", FilterTrustedResourceUri(s), fmt.Sprintf("FilterTrustedResourceUri(%q)", s))
+  }
+  trusted := NewSanitizedContent("js/app.js", CONTENT_KIND_TRUSTED_RESOURCE_URI)
+  assertStringEquals(t, "js/app.js", FilterTrustedResourceUriSoyData(trusted), "FilterTrustedResourceUriSoyData of trusted content")
+  assertStringEquals(t, "about:invalid#zSoyz", FilterTrustedResourceUriSoyData(NewSanitizedContent("js/app.js", CONTENT_KIND_URI)), "FilterTrustedResourceUriSoyData of URI content")
+  assertStringEquals(t, "", FilterTrustedResourceUriSoyData(nil), "FilterTrustedResourceUriSoyData(nil)")
+  assertStringEquals(t, "TRUSTED_RESOURCE_URI", CONTENT_KIND_TRUSTED_RESOURCE_URI.String(), "CONTENT_KIND_TRUSTED_RESOURCE_URI.String()")
+  assertStringEquals(t, "URI", CONTENT_KIND_URI.String(), "CONTENT_KIND_URI.String()")
+}
+
+func TestEscapeHtmlEscapeSlash(t *testing.T) {
+  assertStringEquals(t, "&lt;&#47;a&gt;", EscapeHtmlEscapeSlash("</a>"), "EscapeHtmlEscapeSlash(\"</a>\")")
+  assertStringEquals(t, "a &amp; b&#47;c &quot;d&quot; &#39;e&#39;", EscapeHtmlEscapeSlash("a & b/c \"d\" 'e'"), "EscapeHtmlEscapeSlash keeps the EscapeHtml escapes")
+  assertStringEquals(t, "&lt;/a&gt;", EscapeHtml("</a>"), "EscapeHtml leaves the slash")
+}
+
+/**
+ * Returns at most n bytes per Read, so multi-byte runes get split across reads.
+ */
+type chunkReader struct {
+  r io.Reader
+  n int
+}
+
+func (p *chunkReader) Read(b []byte) (int, error) {
+  if len(b) > p.n {
+    b = b[:p.n]
+  }
+  return p.r.Read(b)
+}
+
+func TestEscapeReader(t *testing.T) {
+  s := "<a href=\"x\">O'Reilly & é \U0001F600</a> "
+  large := strings.Repeat(s, 1000)
+  readers := map[string]func(string) io.Reader{
+    "one byte": func(v string) io.Reader { return iotest.OneByteReader(strings.NewReader(v)) },
+    "three bytes": func(v string) io.Reader { return &chunkReader{strings.NewReader(v), 3} },
+    "large": func(v string) io.Reader { return &chunkReader{strings.NewReader(v), 8192} },
+  }
+  type readerEscaper interface {
+    CrossLanguageStringXform
+    EscapeReader(r io.Reader) io.Reader
+  }
+  for _, escaper := range []readerEscaper{EscapeHtmlInstance, EscapeJsStringInstance, EscapeUriInstance} {
+    for name, newReader := range readers {
+      for _, input := range []string{"", s, large} {
+        expected, _ := escaper.Escape(input)
+        actual, err := ioutil.ReadAll(escaper.EscapeReader(newReader(input)))
+        if err != nil {
+          t.Errorf("%s EscapeReader with %s reads returned error: %v", escaper.DirectiveName(), name, err)
+        }
+        assertStringEquals(t, expected, string(actual), fmt.Sprintf("%s EscapeReader with %s reads of %d bytes", escaper.DirectiveName(), name, len(input)))
+      }
+    }
+  }
+}
@@ -2,10 +2,15 @@ package soyutil;
 
 import (
   "bytes"
+  "fmt"
   "math"
+  "math/big"
   "math/rand"
+  "sort"
   "strconv"
   "strings"
+  "time"
+  "unicode/utf8"
 )
 
 type Lener interface {
@@ -20,6 +25,30 @@ func Conditional(cond bool, iftrue SoyData, iffalse SoyData) SoyData {
   return iffalse
 }
 
+/**
+ * Implements Soy's {switch} over SoyData values.
+ * Returns the result paired with the first case that Equals value, or deflt if no case matches.
+ * Panics with a SoyDataException if cases and results do not have the same length, since that
+ * is a bug in the caller rather than a property of the data.
+ */
+func Switch(value SoyData, cases []SoyData, results []SoyData, deflt SoyData) SoyData {
+  if len(cases) != len(results) {
+    panic(NewSoyDataException(fmt.Sprintf("Switch got %d cases but %d results", len(cases), len(results))))
+  }
+  if value == nil {
+    value = NilDataInstance
+  }
+  for i, c := range cases {
+    if c == nil {
+      c = NilDataInstance
+    }
+    if value.Equals(c) {
+      return results[i]
+    }
+  }
+  return deflt
+}
+
 func InsertWordBreaks(value string, maxCharsBetweenWordBreaks int) string {
   result := bytes.NewBuffer(make([]byte, 0, (len(value) + (len(value) / maxCharsBetweenWordBreaks) + 2)))
 
@@ -89,6 +118,222 @@ func InsertWordBreaks(value string, maxCharsBetweenWordBreaks int) string {
   
 }
 
+/**
+ * Applies {@link InsertWordBreaks} to every string element of a list.
+ * Plain strings are HTML escaped first and HTML sanitized content is used as is; both are
+ * returned as HTML sanitized content.  Elements that are not strings are kept unchanged, as
+ * are all elements when maxChars is not positive.
+ * @param {SoyListData} l The list whose elements should get word breaks.
+ * @param {number} maxChars The maximum number of characters between word breaks.
+ * @return {SoyListData} A new list.
+ */
+func InsertWordBreaksList(l SoyListData, maxChars int) SoyListData {
+  result := NewSoyListData()
+  if l == nil {
+    return result
+  }
+  for e := l.Front(); e != nil; e = e.Next() {
+    if maxChars <= 0 {
+      result.PushBack(e.Value.(SoyData))
+      continue
+    }
+    switch v := e.Value.(type) {
+    case StringData:
+      result.PushBack(NewSanitizedContent(InsertWordBreaks(EscapeHtml(v.Value()), maxChars), CONTENT_KIND_HTML))
+    case *SanitizedContent:
+      if v.ContentKind() == CONTENT_KIND_HTML {
+        result.PushBack(NewSanitizedContent(InsertWordBreaks(v.Content(), maxChars), CONTENT_KIND_HTML))
+      } else {
+        result.PushBack(NewSanitizedContent(InsertWordBreaks(EscapeHtml(v.Content()), maxChars), CONTENT_KIND_HTML))
+      }
+    default:
+      result.PushBack(e.Value.(SoyData))
+    }
+  }
+  return result
+}
+
+/**
+ * Computes the number of characters a reader would see in the given text.
+ * If isHtml, tags, comments, and DOCTYPEs are not counted and each entity counts as a single
+ * character, so {@code a&amp;b} has a visible length of 3 and {@code <b>hi</b>} of 2.
+ * @param {string} htmlOrText The text to measure.
+ * @param {boolean} isHtml Whether the text is HTML / HTML-escaped.
+ * @return {number} The number of visible runes.
+ */
+func VisibleLength(htmlOrText string, isHtml bool) int {
+  if !isHtml {
+    return utf8.RuneCountInString(htmlOrText)
+  }
+  length := 0
+  for i := 0; i < len(htmlOrText); {
+    n := htmlMarkupLen(htmlOrText[i:])
+    if n > 0 {
+      i += n
+      continue
+    }
+    n = htmlEntityLen(htmlOrText[i:])
+    if n == 0 {
+      _, n = utf8.DecodeRuneInString(htmlOrText[i:])
+    }
+    i += n
+    length++
+  }
+  return length
+}
+
+/**
+ * HTML elements which never have an end tag, so they are never left open by a truncation.
+ */
+var _HTML_VOID_ELEMENTS = map[string]bool{
+  "area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+  "input": true, "link": true, "meta": true, "param": true, "source": true, "track": true,
+  "wbr": true,
+}
+
+/**
+ * Truncates plain text to at most maxLen characters, as the {@code |truncate} directive does.
+ * @param {string} str The text to truncate.
+ * @param {number} maxLen The maximum length of the result, including the ellipsis.
+ * @param {boolean} addEllipsis Whether to end truncated text with "...".  The ellipsis is only
+ *     added if maxLen is greater than 3.
+ * @return {string} str, or its first characters if it is longer than maxLen.
+ */
+func Truncate(str string, maxLen int, addEllipsis bool) string {
+  if maxLen < 0 {
+    maxLen = 0
+  }
+  if utf8.RuneCountInString(str) <= maxLen {
+    return str
+  }
+  if addEllipsis && maxLen > 3 {
+    maxLen -= 3
+  } else {
+    addEllipsis = false
+  }
+  // Find the byte offset of the cut so a multi-byte character is never split.
+  cut := len(str)
+  n := 0
+  for i := range str {
+    if n == maxLen {
+      cut = i
+      break
+    }
+    n++
+  }
+  if addEllipsis {
+    return str[0:cut] + "..."
+  }
+  return str[0:cut]
+}
+
+/**
+ * Truncates HTML to at most maxVisible visible characters (see {@link VisibleLength}).
+ * The cut never falls inside a tag or an entity, and any elements that are open at the cut point
+ * are closed so the result is balanced.
+ * @param {string} s The HTML to truncate.
+ * @param {number} maxVisible The maximum number of visible characters, including the ellipsis.
+ * @param {boolean} addEllipsis Whether to add "..." when the content is truncated.
+ * @return {SanitizedContent} The truncated HTML.
+ */
+func TruncateHtml(s string, maxVisible int, addEllipsis bool) *SanitizedContent {
+  if VisibleLength(s, true) <= maxVisible {
+    return NewSanitizedContent(s, CONTENT_KIND_HTML)
+  }
+  ellipsis := ""
+  if addEllipsis {
+    if maxVisible > 3 {
+      maxVisible -= 3
+      ellipsis = "..."
+    } else {
+      addEllipsis = false
+    }
+  }
+  openTags := make([]string, 0)
+  count := 0
+  i := 0
+  for i < len(s) && count < maxVisible {
+    if n := htmlMarkupLen(s[i:]); n > 0 {
+      tag := s[i:i+n]
+      i += n
+      name, isEndTag := htmlTagName(tag)
+      switch {
+      case name == "" || _HTML_VOID_ELEMENTS[name] || strings.HasSuffix(tag, "/>"):
+      case isEndTag:
+        for j := len(openTags) - 1; j >= 0; j-- {
+          if openTags[j] == name {
+            openTags = openTags[0:j]
+            break
+          }
+        }
+      default:
+        openTags = append(openTags, name)
+      }
+      continue
+    }
+    n := htmlEntityLen(s[i:])
+    if n == 0 {
+      _, n = utf8.DecodeRuneInString(s[i:])
+    }
+    i += n
+    count++
+  }
+  buf := bytes.NewBufferString(s[0:i])
+  buf.WriteString(ellipsis)
+  for j := len(openTags) - 1; j >= 0; j-- {
+    buf.WriteString("</" + openTags[j] + ">")
+  }
+  return NewSanitizedContent(buf.String(), CONTENT_KIND_HTML)
+}
+
+/**
+ * Returns the lower case element name of a tag found by {@link htmlMarkupLen}, and whether it is
+ * an end tag.  Comments and DOCTYPEs have an empty name.
+ */
+func htmlTagName(tag string) (string, bool) {
+  isEndTag := strings.HasPrefix(tag, "</")
+  start := 1
+  if isEndTag {
+    start = 2
+  }
+  end := start
+  for end < len(tag) {
+    c := tag[end]
+    if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == ':') {
+      break
+    }
+    end++
+  }
+  return strings.ToLower(tag[start:end]), isEndTag
+}
+
+/**
+ * Returns the length in bytes of the tag, comment, or DOCTYPE at the start of s, or 0 if s does
+ * not start with one.
+ */
+func htmlMarkupLen(s string) int {
+  if len(s) == 0 || s[0] != '<' {
+    return 0
+  }
+  if loc := _HTML_TAG_PREFIX_RE.FindStringIndex(s); loc != nil {
+    return loc[1]
+  }
+  return 0
+}
+
+/**
+ * Returns the length in bytes of the entity at the start of s, or 0 if s does not start with one.
+ */
+func htmlEntityLen(s string) int {
+  if len(s) == 0 || s[0] != '&' {
+    return 0
+  }
+  if loc := _HTML_ENTITY_PREFIX_RE.FindStringIndex(s); loc != nil {
+    return loc[1]
+  }
+  return 0
+}
+
 /**
  * Converts \r\n, \r, and \n to <br>s
  * @param {*} str The string in which to convert newlines.
@@ -166,6 +411,11 @@ func LessThan(a, b SoyData) BooleanData {
   if b == nil {
     b = NilDataInstance
   }
+  if ai, ok := integralValue(a); ok {
+    if bi, ok := integralValue(b); ok {
+      return NewBooleanData(ai < bi)
+    }
+  }
   a1 := a.NumberValue()
   b1 := b.NumberValue()
   return NewBooleanData(a1 < b1)
@@ -178,6 +428,11 @@ func GreaterThan(a, b SoyData) SoyData {
   if b == nil {
     b = NilDataInstance
   }
+  if ai, ok := integralValue(a); ok {
+    if bi, ok := integralValue(b); ok {
+      return NewBooleanData(ai > bi)
+    }
+  }
   a1 := a.NumberValue()
   b1 := b.NumberValue()
   return NewBooleanData(a1 > b1)
@@ -190,6 +445,11 @@ func LessThanOrEqual(a, b SoyData) BooleanData {
   if b == nil {
     b = NilDataInstance
   }
+  if ai, ok := integralValue(a); ok {
+    if bi, ok := integralValue(b); ok {
+      return NewBooleanData(ai <= bi)
+    }
+  }
   a1 := a.NumberValue()
   b1 := b.NumberValue()
   return NewBooleanData(a1 <= b1)
@@ -202,6 +462,11 @@ func GreaterThanOrEqual(a, b SoyData) SoyData {
   if b == nil {
     b = NilDataInstance
   }
+  if ai, ok := integralValue(a); ok {
+    if bi, ok := integralValue(b); ok {
+      return NewBooleanData(ai >= bi)
+    }
+  }
   a1 := a.NumberValue()
   b1 := b.NumberValue()
   return NewBooleanData(a1 >= b1)
@@ -236,6 +501,41 @@ func Round(a SoyData) SoyData {
   return NewFloat64Data(round(a1))
 }
 
+/**
+ * Rounds to an integer using the given mode.
+ */
+func RoundWithMode(a SoyData, mode RoundMode) SoyData {
+  if a == nil {
+    return NewFloat64Data(defaultFloat64Value())
+  }
+  a1 := a.NumberValue()
+  var output float64
+  switch mode {
+  case ROUND_HALF_EVEN:
+    output = round(a1)
+    if math.Abs(a1 - math.Trunc(a1)) == 0.5 && math.Mod(output, 2) != 0 {
+      // A tie that was rounded away from zero to an odd number; go back towards zero.
+      if output > 0 {
+        output--
+      } else {
+        output++
+      }
+    }
+  case ROUND_FLOOR:
+    output = math.Floor(a1)
+  case ROUND_CEIL:
+    output = math.Ceil(a1)
+  default:
+    output = round(a1)
+  }
+  return NewFloat64Data(output)
+}
+
+/**
+ * The largest number of digits, on either side of the decimal point, that Round2 will round to.
+ */
+const _MAX_ROUND_DIGITS = 308
+
 func Round2(a, b SoyData) SoyData {
   if a == nil {
     a = NilDataInstance
@@ -245,8 +545,143 @@ func Round2(a, b SoyData) SoyData {
   }
   a1 := a.NumberValue()
   b1 := b.IntegerValue()
+  if math.IsNaN(a1) || math.IsInf(a1, 0) {
+    return NewFloat64Data(a1)
+  }
+  // A float64 has no digits beyond these, so clamp the digit count rather than let
+  // Pow10 overflow to +Inf or underflow to 0.
+  if b1 > _MAX_ROUND_DIGITS {
+    return NewFloat64Data(a1)
+  }
+  if b1 < -_MAX_ROUND_DIGITS {
+    return NewFloat64Data(0)
+  }
+  if b1 < 0 {
+    // Rounding to tens, hundreds, etc.  Dividing by an exact power of ten avoids the
+    // inexact multiplier that Pow10 returns for negative exponents.
+    divisor := math.Pow10(-b1)
+    return NewFloat64Data(round(a1 / divisor) * divisor)
+  }
   multiplier := math.Pow10(b1)
-  return NewFloat64Data(round(a1 * multiplier) / multiplier)
+  scaled := a1 * multiplier
+  if math.IsInf(scaled, 0) {
+    // Too large to have any fractional digits left to round.
+    return NewFloat64Data(a1)
+  }
+  return NewFloat64Data(round(scaled) / multiplier)
+}
+
+/**
+ * Renders a value's truthiness as one of two caller-supplied strings, e.g. "Yes"/"No".
+ * @param {*} b The value to test; coerced with Bool(), so nil is falsy.
+ * @param {string} trueStr The string to return when b is truthy.
+ * @param {string} falseStr The string to return when b is falsy.
+ * @return {string} trueStr or falseStr.
+ */
+func FormatBool(b SoyData, trueStr, falseStr string) StringData {
+  if b != nil && b.Bool() {
+    return NewStringData(trueStr)
+  }
+  return NewStringData(falseStr)
+}
+
+/**
+ * Extracts a boolean from a value that really is one, unlike Bool() which reports the truthiness
+ * of any value.
+ * @return The boolean, and true only if s is a BooleanData or sanitized content that is exactly
+ *     "true" or "false".
+ */
+func AsBool(s SoyData) (bool, bool) {
+  switch v := s.(type) {
+  case BooleanData:
+    return bool(v), true
+  case *SanitizedContent:
+    switch v.Content() {
+    case "true":
+      return true, true
+    case "false":
+      return false, true
+    }
+  }
+  return false, false
+}
+
+/**
+ * Formats a timestamp with a Go time layout such as time.RFC1123 or "2006-01-02".
+ * @param {*} s Either an integer number of seconds since the Unix epoch, which is formatted in
+ *     UTC, or a string or sanitized content in RFC 3339 format, which keeps its own offset.
+ * @param {string} layout The layout to format with, as for time.Time.Format.
+ * @return {string} The formatted time, or "" if s is not a timestamp.
+ */
+func FormatTime(s SoyData, layout string) StringData {
+  var t time.Time
+  switch v := s.(type) {
+  case IntegerData:
+    t = time.Unix(int64(v), 0).UTC()
+  case Int64Data:
+    t = time.Unix(int64(v), 0).UTC()
+  case StringData, *SanitizedContent:
+    var err error
+    t, err = time.Parse(time.RFC3339Nano, strings.TrimSpace(v.String()))
+    if err != nil {
+      return NewStringData("")
+    }
+  default:
+    return NewStringData("")
+  }
+  return NewStringData(t.Format(layout))
+}
+
+/**
+ * Formats a number with a fixed number of fraction digits, matching JavaScript's
+ * Number.prototype.toFixed so server and client rendering agree: ties round away from zero
+ * based on the exact binary value, and there is no digit grouping.
+ * @param {*} a The number to format.
+ * @param {number} digits The number of digits after the decimal point, from 0 to 100.
+ * @return {string} The formatted number.
+ */
+func ToFixed(a SoyData, digits int) StringData {
+  if a == nil {
+    a = NilDataInstance
+  }
+  if digits < 0 {
+    digits = 0
+  } else if digits > 100 {
+    digits = 100
+  }
+  x := a.NumberValue()
+  switch {
+  case math.IsNaN(x):
+    return NewStringData("NaN")
+  case math.IsInf(x, 1):
+    return NewStringData("Infinity")
+  case math.IsInf(x, -1):
+    return NewStringData("-Infinity")
+  case math.Abs(x) >= 1e21:
+    return NewStringData(NewFloat64Data(x).String())
+  }
+  sign := ""
+  if x < 0 {
+    sign = "-"
+    x = -x
+  }
+  r := new(big.Rat).SetFloat64(x)
+  r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)))
+  r.Add(r, big.NewRat(1, 2))
+  n := new(big.Int).Quo(r.Num(), r.Denom()).String()
+  if n == "0" {
+    // Like JavaScript, (-0.001).toFixed(2) is "-0.00", but (-0).toFixed(2) is "0.00".
+    if x == 0 {
+      sign = ""
+    }
+  }
+  if len(n) <= digits {
+    n = strings.Repeat("0", digits - len(n) + 1) + n
+  }
+  if digits > 0 {
+    n = n[0:len(n)-digits] + "." + n[len(n)-digits:]
+  }
+  return NewStringData(sign + n)
 }
 
 func Min(a, b SoyData) SoyData {
@@ -289,6 +724,34 @@ func Ceiling(a float64) SoyData {
   return NewFloat64Data(math.Ceil(a))
 }
 
+func Sqrt(a SoyData) SoyData {
+  if a == nil {
+    a = NilDataInstance
+  }
+  return NewFloat64Data(math.Sqrt(a.NumberValue()))
+}
+
+/**
+ * Whether the numeric value of s is NaN, e.g. the result of Sqrt(-1).
+ */
+func IsNaN(s SoyData) BooleanData {
+  if s == nil {
+    return NewBooleanData(false)
+  }
+  return NewBooleanData(math.IsNaN(s.NumberValue()))
+}
+
+/**
+ * Whether the numeric value of s is neither NaN nor infinite, so it is safe to render.
+ */
+func IsFinite(s SoyData) BooleanData {
+  if s == nil {
+    return NewBooleanData(true)
+  }
+  v := s.NumberValue()
+  return NewBooleanData(!math.IsNaN(v) && !math.IsInf(v, 0))
+}
+
 func Len(a SoyData) SoyData {
   if a == nil {
     a = NilDataInstance
@@ -308,9 +771,58 @@ func RandomInt(a int) IntegerData {
   return IntegerData(rand.Intn(a))
 }
 
+/**
+ * Rewrites the bracket index segments of a path as dotted segments, e.g. "a.b[2].c" as
+ * "a.b.2.c".
+ * @return The dotted path, and false if a bracket is unterminated, is not followed by a dot or
+ *     another bracket, or does not contain a non-negative integer.
+ */
+func bracketPathToDotted(key string) (string, bool) {
+  var buf bytes.Buffer
+  for len(key) > 0 {
+    open := strings.Index(key, "[")
+    if open < 0 {
+      buf.WriteString(key)
+      break
+    }
+    buf.WriteString(key[0:open])
+    end := strings.Index(key[open:], "]")
+    if end < 0 {
+      return "", false
+    }
+    end += open
+    index := key[open+1:end]
+    if n, err := strconv.Atoi(index); err != nil || n < 0 || index[0] == '+' {
+      return "", false
+    }
+    if buf.Len() > 0 {
+      buf.WriteByte('.')
+    }
+    buf.WriteString(index)
+    key = key[end+1:]
+    if len(key) > 0 && key[0] != '.' && key[0] != '[' {
+      return "", false
+    }
+  }
+  return buf.String(), true
+}
+
+/**
+ * Looks up a dotted path such as "a.b.2.c" in nested maps and lists.  List indices may also be
+ * written in brackets, e.g. "a.b[2].c".
+ * @return The value found, UndefinedDataInstance if the path does not resolve, or
+ *     NilDataInstance if a bracket index is malformed or negative.
+ */
 func GetData(data SoyData, key string) SoyData {
   if data == nil {
-    return NilDataInstance
+    return UndefinedDataInstance
+  }
+  if strings.Contains(key, "[") {
+    dotted, ok := bracketPathToDotted(key)
+    if !ok {
+      return NilDataInstance
+    }
+    key = dotted
   }
   dotIndex := strings.Index(key, ".")
   keypart := key
@@ -321,9 +833,9 @@ func GetData(data SoyData, key string) SoyData {
   }
   switch d := data.(type) {
   case SoyListData:
-    lindex, err := strconv.Atoi(keyleft)
-    if err == nil {
-      return NilDataInstance
+    lindex, err := strconv.Atoi(keypart)
+    if err != nil || lindex < 0 || lindex >= d.Len() {
+      return UndefinedDataInstance
     }
     v := d.At(lindex)
     if len(keyleft) == 0 {
@@ -333,16 +845,34 @@ func GetData(data SoyData, key string) SoyData {
   case SoyMapData:
     v, found := d[keypart]
     if !found {
-      return NilDataInstance
+      return UndefinedDataInstance
     }
     if len(keyleft) == 0 {
       return v
     }
     return GetData(v, keyleft)
+  case *OrderedSoyMapData:
+    return GetData(d.SoyMapData(), key)
   default:
-    return NilDataInstance
+    return UndefinedDataInstance
+  }
+  return UndefinedDataInstance
+}
+
+/**
+ * Returns the map found at path within m so that repeated reads of a nested object don't have
+ * to walk the whole path each time.  The returned map is shared with m, not copied.
+ * @return The nested map, and false if path does not resolve to a map.
+ */
+func Scope(m SoyMapData, path string) (SoyMapData, bool) {
+  if m == nil {
+    return nil, false
   }
-  return NilDataInstance
+  if path == "" {
+    return m, true
+  }
+  scoped, ok := GetData(m, path).(SoyMapData)
+  return scoped, ok
 }
 
 /**
@@ -359,16 +889,240 @@ func GetData(data SoyData, key string) SoyData {
  *     and the additional params.
  */
 func AugmentData(a, b SoyMapData) SoyMapData {
-  if a == nil {
-    a = NewSoyMapData()
-  }
-  if b == nil {
-    b = NewSoyMapData()
+  result := make(SoyMapData, len(a) + len(b))
+  for k, v := range a {
+    result[k] = v
   }
   for k, v := range b {
-    a[k] = v
+    result[k] = v
+  }
+  return result
+}
+
+/**
+ * Checks that m has a value of the expected content kind for every key in specs, so that data
+ * shape bugs are reported before rendering rather than as odd output.  A field is missing if
+ * its key is absent or undefined, and wrong-typed unless it is a SanitizedContent of the
+ * expected kind.
+ * @return One error per missing or wrong-typed field, in key order; empty if m is valid.
+ */
+func ValidateFields(m SoyMapData, specs map[string]ContentKind) []error {
+  keys := make([]string, 0, len(specs))
+  for k := range specs {
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+  errs := make([]error, 0)
+  for _, k := range keys {
+    v := m.Get(k)
+    if IsUndefined(v) {
+      errs = append(errs, NewSoyDataException(fmt.Sprintf("Missing required field %q", k)))
+      continue
+    }
+    if sc, ok := v.(*SanitizedContent); !ok || sc.ContentKind() != specs[k] {
+      errs = append(errs, NewSoyDataException(fmt.Sprintf("Field %q is %s, expected %s content", k, soyDataKindName(v), specs[k])))
+    }
+  }
+  return errs
+}
+
+/**
+ * Names the Soy type of d for error messages, e.g. "string" or "HTML content".
+ */
+func soyDataKindName(d SoyData) string {
+  switch v := d.(type) {
+  case nil, NilData, *NilData:
+    return "null"
+  case UndefinedData, *UndefinedData:
+    return "undefined"
+  case *SanitizedContent:
+    return v.ContentKind().String() + " content"
+  case BooleanData:
+    return "bool"
+  case IntegerData, Int64Data:
+    return "int"
+  case Float64Data:
+    return "float"
+  case StringData:
+    return "string"
+  case SoyListData:
+    return "list"
+  case SoyMap:
+    return "map"
+  }
+  return "unknown"
+}
+
+/**
+ * Returns a new list without duplicate elements, keeping the first occurrence of each value in
+ * its original position.  Duplicates are found with a SoyDataSet, so only values with the same
+ * HashCode() that are Soy '==' are merged.
+ */
+func UniqList(l SoyListData) SoyListData {
+  set := NewSoyDataSet()
+  if l != nil {
+    for e := l.Front(); e != nil; e = e.Next() {
+      set.Add(e.Value.(SoyData))
+    }
+  }
+  return set.ToList()
+}
+
+/**
+ * Flattens a list of lists by one level: elements that are lists are replaced by their
+ * elements, other elements are kept in place.  Lists nested more deeply are not flattened.
+ */
+func FlattenList(l SoyListData) SoyListData {
+  result := NewSoyListData()
+  if l == nil {
+    return result
+  }
+  for e := l.Front(); e != nil; e = e.Next() {
+    switch v := e.Value.(type) {
+    case *NilData, *UndefinedData:
+      // NilData also implements SoyListData but is a value, not a list.
+      result.PushBack(e.Value.(SoyData))
+    case SoyListData:
+      for inner := v.Front(); inner != nil; inner = inner.Next() {
+        result.PushBack(inner.Value.(SoyData))
+      }
+    default:
+      result.PushBack(e.Value.(SoyData))
+    }
+  }
+  return result
+}
+
+/**
+ * Indexes a list of maps by one of their fields, e.g. users by "id", for lookups in a template.
+ * The key is the string form of the field; when two maps share a key the later one wins.
+ * Elements that are not maps, or do not have the field, are skipped.
+ */
+func IndexListBy(l SoyListData, keyField string) SoyMapData {
+  result := NewSoyMapData()
+  if l == nil {
+    return result
+  }
+  for e := l.Front(); e != nil; e = e.Next() {
+    m, ok := e.Value.(SoyMapData)
+    if !ok {
+      continue
+    }
+    if key := m.Get(keyField); !IsUndefined(key) {
+      result.Set(key.String(), m)
+    }
+  }
+  return result
+}
+
+/**
+ * Builds a map from a list of alternating keys and values, like NewSoyMapDataFromArgs does for
+ * its arguments.  Keys are converted with String(); a later duplicate key wins.
+ * @return The map, or an error if the list has an odd number of elements or a null or
+ *     undefined key.
+ */
+func MapFromPairsList(l SoyListData) (SoyMapData, error) {
+  result := NewSoyMapData()
+  if l == nil {
+    return result, nil
+  }
+  if l.Len() % 2 != 0 {
+    return nil, NewSoyDataException(fmt.Sprintf("Expected alternating keys and values but the list has %d elements", l.Len()))
+  }
+  for i, e := 0, l.Front(); e != nil; i, e = i + 2, e.Next().Next() {
+    if isNullData(e.Value) {
+      return nil, NewSoyDataException(fmt.Sprintf("Expected a key at index %d but found %v", i, e.Value))
+    }
+    result.Set(e.Value.(SoyData).String(), e.Next().Value.(SoyData))
+  }
+  return result, nil
+}
+
+/**
+ * Pairs up the elements of two lists, e.g. labels with their values, so they can be iterated
+ * together.  Each element of the result is a two element list; the result is as long as the
+ * shorter input.
+ */
+func ZipLists(a, b SoyListData) SoyListData {
+  result := NewSoyListData()
+  if a == nil || b == nil {
+    return result
+  }
+  for ea, eb := a.Front(), b.Front(); ea != nil && eb != nil; ea, eb = ea.Next(), eb.Next() {
+    pair := NewSoyListData()
+    pair.PushBack(ea.Value.(SoyData))
+    pair.PushBack(eb.Value.(SoyData))
+    result.PushBack(pair)
+  }
+  return result
+}
+
+/**
+ * Whether the string form of s starts with the string form of prefix.
+ * An empty prefix always matches.
+ */
+func StrStartsWith(s, prefix SoyData) BooleanData {
+  if s == nil {
+    s = NilDataInstance
+  }
+  if prefix == nil {
+    return NewBooleanData(true)
+  }
+  return NewBooleanData(strings.HasPrefix(s.String(), prefix.String()))
+}
+
+/**
+ * Whether the string form of s ends with the string form of suffix.
+ * An empty suffix always matches.
+ */
+func StrEndsWith(s, suffix SoyData) BooleanData {
+  if s == nil {
+    s = NilDataInstance
+  }
+  if suffix == nil {
+    return NewBooleanData(true)
+  }
+  return NewBooleanData(strings.HasSuffix(s.String(), suffix.String()))
+}
+
+/**
+ * Pads the string form of s on the left with pad, repeated and cut short as needed, until it is
+ * length characters long, e.g. to zero-pad a number.  Strings that are already at least length
+ * characters long, or an empty pad, leave s unchanged.
+ */
+func StrPadStart(s SoyData, length int, pad string) StringData {
+  if s == nil {
+    s = NilDataInstance
+  }
+  str := s.String()
+  return NewStringData(strPadding(str, length, pad) + str)
+}
+
+/**
+ * Like {@link StrPadStart} but pads on the right.
+ */
+func StrPadEnd(s SoyData, length int, pad string) StringData {
+  if s == nil {
+    s = NilDataInstance
+  }
+  str := s.String()
+  return NewStringData(str + strPadding(str, length, pad))
+}
+
+/**
+ * Returns the padding needed to bring str up to length characters.
+ */
+func strPadding(str string, length int, pad string) string {
+  missing := length - utf8.RuneCountInString(str)
+  if missing <= 0 || pad == "" {
+    return ""
+  }
+  padRunes := []rune(pad)
+  padding := make([]rune, missing)
+  for i := range padding {
+    padding[i] = padRunes[i % len(padRunes)]
   }
-  return a
+  return string(padding)
 }
 
 func BoolToInt(value bool) int {
@@ -2,6 +2,7 @@ package soyutil_test;
 
 import (
   . "closure/template/soyutil"
+  "fmt"
   "testing"
 )
 
@@ -23,6 +24,35 @@ func TestGetData(t *testing.T) {
   assertStringEquals(t, "Albert Einstein", l.At(0).StringValue(), "GetData(m, \"names\").At(0)")
   assertStringEquals(t, "Lawrence of Arabia", l.At(1).StringValue(), "GetData(m, \"names\").At(1)")
   assertStringEquals(t, "Beetlejuice", l.At(2).StringValue(), "GetData(m, \"names\").At(2)")
+  assertStringEquals(t, "Lawrence of Arabia", GetData(m, "names.1").String(), "GetData(m, \"names.1\")")
+  assertSoyDataEquals(t, UndefinedDataInstance, GetData(m, "names.3"), "GetData(m, \"names.3\")")
+  assertSoyDataEquals(t, UndefinedDataInstance, GetData(m, "names.x"), "GetData(m, \"names.x\")")
+
+  matrix := NewSoyMapDataFromArgs("matrix", NewSoyListDataFromArgs(NewSoyListDataFromArgs(1, 2, 3), NewSoyListDataFromArgs(4, 5, 6)))
+  assertSoyDataEquals(t, NewIntegerData(3), GetData(matrix, "matrix.0.2"), "GetData(m, \"matrix.0.2\")")
+  assertSoyDataEquals(t, NewIntegerData(4), GetData(matrix, "matrix.1.0"), "GetData(m, \"matrix.1.0\")")
+  items := NewSoyMapDataFromArgs("items", NewSoyListDataFromArgs(NewSoyMapDataFromArgs("name", "first")))
+  assertStringEquals(t, "first", GetData(items, "items.0.name").String(), "GetData(m, \"items.0.name\")")
+}
+
+func TestGetDataBracketIndex(t *testing.T) {
+  m := NewSoyMapDataFromArgs("a", NewSoyMapDataFromArgs("b", NewSoyListDataFromArgs("x", "y", NewSoyMapDataFromArgs("c", "found"))))
+  assertStringEquals(t, "found", GetData(m, "a.b[2].c").String(), "GetData(m, \"a.b[2].c\")")
+  assertStringEquals(t, "found", GetData(m, "a.b.2.c").String(), "GetData(m, \"a.b.2.c\")")
+  assertStringEquals(t, "y", GetData(m, "a.b[1]").String(), "GetData(m, \"a.b[1]\")")
+  assertBoolEquals(t, true, IsUndefined(GetData(m, "a.b[3].c")), "GetData(m, \"a.b[3].c\") should be undefined")
+
+  matrix := NewSoyMapDataFromArgs("matrix", NewSoyListDataFromArgs(NewSoyListDataFromArgs(1, 2, 3), NewSoyListDataFromArgs(4, 5, 6)))
+  assertSoyDataEquals(t, NewIntegerData(6), GetData(matrix, "matrix[1][2]"), "GetData(m, \"matrix[1][2]\")")
+  assertSoyDataEquals(t, NewIntegerData(2), GetData(matrix, "matrix[0].1"), "GetData(m, \"matrix[0].1\")")
+  assertSoyDataEquals(t, NewIntegerData(4), GetData(NewSoyListDataFromArgs(NewSoyListDataFromArgs(4), 5), "[0][0]"), "GetData(l, \"[0][0]\")")
+
+  for _, key := range []string{"a.b[-1].c", "a.b[x].c", "a.b[1.5]", "a.b[]", "a.b[2", "a.b[2]c", "a.b[+1]"} {
+    v := GetData(m, key)
+    if _, ok := v.(*NilData); !ok {
+      t.Errorf("GetData(m, %q) should be NilDataInstance, got %#v", key, v)
+    }
+  }
 }
 
 func TestRound2(t *testing.T) {
@@ -32,3 +62,311 @@ func TestRound2(t *testing.T) {
   assertFloat64Equals(t, 3.0, Round2(NewFloat64Data(3.14159), NewIntegerData(0)).Float64Value(), "")
 }
 
+func TestRound2NegativeDigits(t *testing.T) {
+  assertFloat64Equals(t, 1200, Round2(NewIntegerData(1234), NewIntegerData(-2)).Float64Value(), "Round2(1234, -2)")
+  assertFloat64Equals(t, 1300, Round2(NewIntegerData(1250), NewIntegerData(-2)).Float64Value(), "Round2(1250, -2)")
+  assertFloat64Equals(t, -1300, Round2(NewIntegerData(-1250), NewIntegerData(-2)).Float64Value(), "Round2(-1250, -2)")
+  assertFloat64Equals(t, 1230, Round2(NewFloat64Data(1234.5), NewIntegerData(-1)).Float64Value(), "Round2(1234.5, -1)")
+  assertFloat64Equals(t, 0, Round2(NewIntegerData(1234), NewIntegerData(-4)).Float64Value(), "Round2(1234, -4)")
+  assertFloat64Equals(t, 0, Round2(NewIntegerData(1234), NewIntegerData(-1000)).Float64Value(), "Round2(1234, -1000)")
+  assertFloat64Equals(t, 1.5, Round2(NewFloat64Data(1.5), NewIntegerData(1000)).Float64Value(), "Round2(1.5, 1000)")
+  assertFloat64Equals(t, 1e300, Round2(NewFloat64Data(1e300), NewIntegerData(100)).Float64Value(), "Round2(1e300, 100)")
+}
+
+
+func TestVisibleLength(t *testing.T) {
+  assertIntEquals(t, 3, VisibleLength("a&amp;b", true), "VisibleLength(\"a&amp;b\", true)")
+  assertIntEquals(t, 7, VisibleLength("a&amp;b", false), "VisibleLength(\"a&amp;b\", false)")
+  assertIntEquals(t, 2, VisibleLength("<b>hi</b>", true), "VisibleLength(\"<b>hi</b>\", true)")
+  assertIntEquals(t, 4, VisibleLength("<a href=\"x>y\">&#169;&#xA9;</a> &lt;", true), "VisibleLength with entities and a quoted >")
+  assertIntEquals(t, 5, VisibleLength("1 < 2", true), "VisibleLength with a stray <")
+  assertIntEquals(t, 3, VisibleLength("<i>été</i>", true), "VisibleLength counts runes")
+}
+
+func TestTruncateHtml(t *testing.T) {
+  html := "<b>hello <i>world</i></b>"
+  assertStringEquals(t, html, TruncateHtml(html, 11, true).Content(), "TruncateHtml when it fits")
+  assertStringEquals(t, "<b>hello <i>wo</i></b>", TruncateHtml(html, 8, false).Content(), "TruncateHtml mid-word")
+  assertStringEquals(t, "<b>hello </b>", TruncateHtml(html, 6, false).Content(), "TruncateHtml before a tag")
+  assertStringEquals(t, "<b>hello <i>w...</i></b>", TruncateHtml(html, 10, true).Content(), "TruncateHtml with ellipsis")
+  assertStringEquals(t, "a&amp;<br>b", TruncateHtml("a&amp;<br>bc", 3, false).Content(), "TruncateHtml counts entities once")
+  if TruncateHtml(html, 3, false).ContentKind() != CONTENT_KIND_HTML {
+    t.Errorf("TruncateHtml should produce HTML")
+  }
+}
+
+func TestInsertWordBreaksList(t *testing.T) {
+  l := NewSoyListDataFromArgs("http://example.com/a/very/long/path", "short", 42)
+  r := InsertWordBreaksList(l, 10)
+  assertIntEquals(t, 3, r.Len(), "InsertWordBreaksList(l).Len()")
+  url, ok := r.At(0).(*SanitizedContent)
+  if !ok || url.ContentKind() != CONTENT_KIND_HTML {
+    t.Errorf("InsertWordBreaksList should wrap strings as HTML but got %#v", r.At(0))
+  }
+  assertStringEquals(t, "http://exa<wbr>mple.com/a<wbr>/very/long<wbr>/path", r.At(0).String(), "InsertWordBreaksList long url")
+  assertStringEquals(t, "short", r.At(1).String(), "InsertWordBreaksList short word")
+  assertSoyDataEquals(t, NewIntegerData(42), r.At(2), "InsertWordBreaksList non-string")
+  assertStringEquals(t, "a&lt;b", InsertWordBreaksList(NewSoyListDataFromArgs("a<b"), 10).At(0).String(), "InsertWordBreaksList escapes plain text")
+  for _, maxChars := range []int{0, -1} {
+    r = InsertWordBreaksList(l, maxChars)
+    assertIntEquals(t, 3, r.Len(), fmt.Sprintf("InsertWordBreaksList(l, %d).Len()", maxChars))
+    for i := 0; i < 3; i++ {
+      assertSoyDataEquals(t, l.At(i), r.At(i), fmt.Sprintf("InsertWordBreaksList(l, %d) element %d", maxChars, i))
+    }
+  }
+}
+
+func TestSwitch(t *testing.T) {
+  cases := []SoyData{NewStringData("a"), NewIntegerData(2), NewStringData("c")}
+  results := []SoyData{NewStringData("first"), NewStringData("second"), NewStringData("third")}
+  deflt := NewStringData("default")
+  assertSoyDataEquals(t, NewStringData("third"), Switch(NewStringData("c"), cases, results, deflt), "Switch matching a case")
+  assertSoyDataEquals(t, deflt, Switch(NewStringData("z"), cases, results, deflt), "Switch hitting the default")
+  assertSoyDataEquals(t, NewStringData("second"), Switch(NewFloat64Data(2.0), cases, results, deflt), "Switch with numeric coercion")
+  defer func() {
+    if _, ok := recover().(*SoyDataException); !ok {
+      t.Errorf("Switch with more cases than results should panic with a SoyDataException")
+    }
+  }()
+  Switch(NewStringData("a"), cases, results[0:2], deflt)
+}
+
+func TestUniqList(t *testing.T) {
+  l := UniqList(NewSoyListDataFromArgs("a", "b", "a", 1, 2.0, 1.0, 2, "b"))
+  assertIntEquals(t, 4, l.Len(), "UniqList(l).Len()")
+  assertSoyDataEquals(t, NewStringData("a"), l.At(0), "UniqList(l).At(0)")
+  assertSoyDataEquals(t, NewStringData("b"), l.At(1), "UniqList(l).At(1)")
+  assertSoyDataEquals(t, NewIntegerData(1), l.At(2), "UniqList(l).At(2)")
+  assertSoyDataEquals(t, NewFloat64Data(2.0), l.At(3), "UniqList(l).At(3)")
+  assertIntEquals(t, 0, UniqList(nil).Len(), "UniqList(nil).Len()")
+}
+
+func TestScope(t *testing.T) {
+  m := NewSoyMapDataFromArgs("user", NewSoyMapDataFromArgs("name", "Ada", "address", NewSoyMapDataFromArgs("city", "London", "zip", "N1")))
+  address, ok := Scope(m, "user.address")
+  assertBoolEquals(t, true, ok, "Scope(m, \"user.address\") should resolve")
+  assertStringEquals(t, "London", GetData(address, "city").String(), "GetData relative to a scope")
+  assertStringEquals(t, "N1", address.Get("zip").String(), "Get relative to a scope")
+  address.Set("country", NewStringData("UK"))
+  assertStringEquals(t, "UK", GetData(m, "user.address.country").String(), "Scope should not copy")
+  _, ok = Scope(m, "user.name")
+  assertBoolEquals(t, false, ok, "Scope(m, \"user.name\") is not a map")
+  _, ok = Scope(m, "user.missing")
+  assertBoolEquals(t, false, ok, "Scope(m, \"user.missing\") does not exist")
+}
+
+func TestRoundWithMode(t *testing.T) {
+  inputs := []float64{0.5, 1.5, 2.5, -0.5}
+  expected := map[RoundMode][]float64{
+    ROUND_HALF_UP: {1, 2, 3, -1},
+    ROUND_HALF_EVEN: {0, 2, 2, 0},
+    ROUND_FLOOR: {0, 1, 2, -1},
+    ROUND_CEIL: {1, 2, 3, 0},
+  }
+  for mode, outputs := range expected {
+    for i, input := range inputs {
+      assertFloat64Equals(t, outputs[i], RoundWithMode(NewFloat64Data(input), mode).Float64Value(), fmt.Sprintf("RoundWithMode(%g, %d)", input, mode))
+    }
+  }
+  assertFloat64Equals(t, 3, Round(NewFloat64Data(2.5)).Float64Value(), "Round should still round half away from zero")
+}
+
+func TestStrStartsWithEndsWith(t *testing.T) {
+  s := NewStringData("template.soy")
+  assertBoolEquals(t, true, StrStartsWith(s, NewStringData("temp")).Value(), "StrStartsWith matching")
+  assertBoolEquals(t, false, StrStartsWith(s, NewStringData("soy")).Value(), "StrStartsWith not matching")
+  assertBoolEquals(t, true, StrStartsWith(s, NewStringData("")).Value(), "StrStartsWith empty prefix")
+  assertBoolEquals(t, true, StrEndsWith(s, NewStringData(".soy")).Value(), "StrEndsWith matching")
+  assertBoolEquals(t, false, StrEndsWith(s, NewStringData("temp")).Value(), "StrEndsWith not matching")
+  assertBoolEquals(t, true, StrEndsWith(s, NewStringData("")).Value(), "StrEndsWith empty suffix")
+  assertBoolEquals(t, true, StrStartsWith(NewIntegerData(1879), NewIntegerData(18)).Value(), "StrStartsWith on numbers")
+}
+
+func TestIsNaNIsFinite(t *testing.T) {
+  inf := Divide(NewIntegerData(1), NewIntegerData(0))
+  nan := Sqrt(NewIntegerData(-1))
+  normal := NewFloat64Data(2.5)
+  assertBoolEquals(t, false, IsNaN(inf).Value(), "IsNaN(1/0)")
+  assertBoolEquals(t, false, IsFinite(inf).Value(), "IsFinite(1/0)")
+  assertBoolEquals(t, true, IsNaN(nan).Value(), "IsNaN(sqrt(-1))")
+  assertBoolEquals(t, false, IsFinite(nan).Value(), "IsFinite(sqrt(-1))")
+  assertBoolEquals(t, false, IsNaN(normal).Value(), "IsNaN(2.5)")
+  assertBoolEquals(t, true, IsFinite(normal).Value(), "IsFinite(2.5)")
+}
+
+func TestToFixed(t *testing.T) {
+  assertStringEquals(t, "3.14", ToFixed(NewFloat64Data(3.14159), 2).Value(), "ToFixed(3.14159, 2)")
+  assertStringEquals(t, "1.00", ToFixed(NewIntegerData(1), 2).Value(), "ToFixed(1, 2)")
+  assertStringEquals(t, "3", ToFixed(NewFloat64Data(2.5), 0).Value(), "ToFixed(2.5, 0)")
+  assertStringEquals(t, "-3", ToFixed(NewFloat64Data(-2.5), 0).Value(), "ToFixed(-2.5, 0)")
+  assertStringEquals(t, "1.00", ToFixed(NewFloat64Data(1.005), 2).Value(), "ToFixed(1.005, 2)")
+  assertStringEquals(t, "0.05", ToFixed(NewFloat64Data(0.05), 2).Value(), "ToFixed(0.05, 2)")
+  assertStringEquals(t, "1234567.9", ToFixed(NewFloat64Data(1234567.89), 1).Value(), "ToFixed(1234567.89, 1)")
+}
+
+func TestFormatBool(t *testing.T) {
+  assertStringEquals(t, "Yes", FormatBool(NewBooleanData(true), "Yes", "No").Value(), "FormatBool(true)")
+  assertStringEquals(t, "No", FormatBool(NewBooleanData(false), "Yes", "No").Value(), "FormatBool(false)")
+  assertStringEquals(t, "No", FormatBool(nil, "Yes", "No").Value(), "FormatBool(nil)")
+  assertStringEquals(t, "No", FormatBool(NilDataInstance, "Yes", "No").Value(), "FormatBool(NilData)")
+}
+
+func TestFormatTime(t *testing.T) {
+  assertStringEquals(t, "2009-02-13 23:31:30", FormatTime(NewIntegerData(1234567890), "2006-01-02 15:04:05").Value(), "FormatTime of an epoch integer")
+  assertStringEquals(t, "1970-01-01", FormatTime(NewInt64Data(0), "2006-01-02").Value(), "FormatTime of an epoch Int64Data")
+  assertStringEquals(t, "Mar 5, 2021 at 14:30 +0100", FormatTime(NewStringData("2021-03-05T14:30:00+01:00"), "Jan 2, 2006 at 15:04 -0700").Value(), "FormatTime of an RFC 3339 string")
+  assertStringEquals(t, "2021-03-05", FormatTime(NewSanitizedContent("2021-03-05T14:30:00.123Z", CONTENT_KIND_HTML), "2006-01-02").Value(), "FormatTime of sanitized content")
+  assertStringEquals(t, "", FormatTime(NewStringData("yesterday"), "2006-01-02").Value(), "FormatTime of an unparseable string")
+  assertStringEquals(t, "", FormatTime(NewFloat64Data(1.5), "2006-01-02").Value(), "FormatTime of a float")
+  assertStringEquals(t, "", FormatTime(nil, "2006-01-02").Value(), "FormatTime(nil)")
+  assertStringEquals(t, "", FormatTime(NilDataInstance, "2006-01-02").Value(), "FormatTime(NilData)")
+}
+
+func TestZipLists(t *testing.T) {
+  z := ZipLists(NewSoyListDataFromArgs(1, 2, 3), NewSoyListDataFromArgs("a", "b"))
+  assertIntEquals(t, 2, z.Len(), "ZipLists truncates to the shorter list")
+  first := z.At(0).(SoyListData)
+  assertSoyDataEquals(t, NewIntegerData(1), first.At(0), "ZipLists first pair, first element")
+  assertSoyDataEquals(t, NewStringData("a"), first.At(1), "ZipLists first pair, second element")
+  second := z.At(1).(SoyListData)
+  assertSoyDataEquals(t, NewIntegerData(2), second.At(0), "ZipLists second pair, first element")
+  assertSoyDataEquals(t, NewStringData("b"), second.At(1), "ZipLists second pair, second element")
+  assertIntEquals(t, 0, ZipLists(nil, NewSoyListDataFromArgs(1)).Len(), "ZipLists with a nil list")
+}
+
+func TestAugmentDataDoesNotModifyOriginal(t *testing.T) {
+  a := NewSoyMapDataFromArgs("name", "original", "kept", 1)
+  b := NewSoyMapDataFromArgs("name", "override", "extra", true)
+  result := AugmentData(a, b)
+  assertIntEquals(t, 2, a.Len(), "original map size after AugmentData")
+  assertStringEquals(t, "original", a.Get("name").String(), "original value after AugmentData")
+  assertBoolEquals(t, false, a.Contains("extra"), "original map should not gain keys")
+  assertIntEquals(t, 3, result.Len(), "augmented map size")
+  assertStringEquals(t, "override", result.Get("name").String(), "augmented value for an overlapping key")
+  assertIntEquals(t, 1, result.Get("kept").IntegerValue(), "augmented value kept from the original")
+  assertIntEquals(t, 0, AugmentData(nil, nil).Len(), "AugmentData(nil, nil)")
+  assertIntEquals(t, 2, AugmentData(nil, a).Len(), "AugmentData(nil, a)")
+}
+
+func TestValidateFields(t *testing.T) {
+  m := NewSoyMapDataFromArgs(
+      "body", NewSanitizedContent("<b>hi</b>", CONTENT_KIND_HTML),
+      "title", "plain text")
+  specs := map[string]ContentKind{
+    "body": CONTENT_KIND_HTML,
+    "title": CONTENT_KIND_HTML,
+    "attrs": CONTENT_KIND_HTML_ATTRIBUTE,
+  }
+  errs := ValidateFields(m, specs)
+  if len(errs) != 2 {
+    t.Fatalf("ValidateFields returned %d errors, expected 2: %v", len(errs), errs)
+  }
+  assertStringEquals(t, "Missing required field \"attrs\"", errs[0].Error(), "error for a missing field")
+  assertStringEquals(t, "Field \"title\" is string, expected HTML content", errs[1].Error(), "error for a wrong-typed field")
+  assertIntEquals(t, 0, len(ValidateFields(m, map[string]ContentKind{"body": CONTENT_KIND_HTML})), "ValidateFields of a valid map")
+  errs = ValidateFields(NewSoyMapDataFromArgs("a", 1, "b", NewSoyListData(), "c", NilDataInstance), map[string]ContentKind{
+    "a": CONTENT_KIND_HTML,
+    "b": CONTENT_KIND_HTML,
+    "c": CONTENT_KIND_URI,
+  })
+  if len(errs) != 3 {
+    t.Fatalf("ValidateFields returned %d errors, expected 3: %v", len(errs), errs)
+  }
+  assertStringEquals(t, "Field \"a\" is int, expected HTML content", errs[0].Error(), "error for an int field")
+  assertStringEquals(t, "Field \"b\" is list, expected HTML content", errs[1].Error(), "error for a list field")
+  assertStringEquals(t, "Field \"c\" is null, expected URI content", errs[2].Error(), "error for a null field")
+}
+
+func TestFlattenList(t *testing.T) {
+  l := FlattenList(NewSoyListDataFromArgs(NewSoyListDataFromArgs(1, 2), NewSoyListDataFromArgs(3), 4))
+  _, differs := Diff(NewSoyListDataFromArgs(1, 2, 3, 4), l)
+  assertBoolEquals(t, false, differs, "FlattenList([[1,2],[3],4])")
+  l = FlattenList(NewSoyListDataFromArgs(nil, NewSoyListDataFromArgs(NewSoyListDataFromArgs(5))))
+  assertIntEquals(t, 2, l.Len(), "FlattenList keeps null and flattens only one level")
+  assertSoyDataEquals(t, NilDataInstance, l.At(0), "FlattenList keeps a null element")
+  assertIntEquals(t, 1, l.At(1).(SoyListData).Len(), "FlattenList leaves deeper lists")
+  assertIntEquals(t, 0, FlattenList(nil).Len(), "FlattenList(nil)")
+}
+
+func TestIndexListBy(t *testing.T) {
+  users := NewSoyListDataFromArgs(
+      NewSoyMapDataFromArgs("id", 1, "name", "Ann"),
+      NewSoyMapDataFromArgs("id", "b2", "name", "Bob"),
+      "not a map",
+      NewSoyMapDataFromArgs("name", "no id"),
+      NewSoyMapDataFromArgs("id", 1, "name", "Ann again"))
+  index := IndexListBy(users, "id")
+  assertIntEquals(t, 2, index.Len(), "IndexListBy size")
+  assertStringEquals(t, "Ann again", GetData(index, "1.name").String(), "IndexListBy keeps the last map for a key")
+  assertStringEquals(t, "Bob", GetData(index, "b2.name").String(), "IndexListBy with a string key")
+  assertIntEquals(t, 0, IndexListBy(nil, "id").Len(), "IndexListBy(nil)")
+}
+
+func TestTruncate(t *testing.T) {
+  assertStringEquals(t, "short", Truncate("short", 10, true), "Truncate of short text")
+  assertStringEquals(t, "abcdefg...", Truncate("abcdefghijklmnop", 10, true), "Truncate with an ellipsis")
+  assertStringEquals(t, "abcdefghij", Truncate("abcdefghijklmnop", 10, false), "Truncate without an ellipsis")
+  assertStringEquals(t, "abc", Truncate("abcdefghijklmnop", 3, true), "Truncate too short for an ellipsis")
+  assertStringEquals(t, "\u00e9\u00e9...", Truncate("\u00e9\u00e9\u00e9\u00e9\u00e9\u00e9", 5, true), "Truncate counts characters, not bytes")
+  assertStringEquals(t, "", Truncate("abc", -1, true), "Truncate with a negative length")
+}
+
+func TestMapFromPairsList(t *testing.T) {
+  m, err := MapFromPairsList(NewSoyListDataFromArgs("name", "Ann", 1, true))
+  if err != nil {
+    t.Fatalf("MapFromPairsList of a four element list failed: %v", err)
+  }
+  assertIntEquals(t, 2, m.Len(), "MapFromPairsList Len")
+  assertStringEquals(t, "Ann", m.Get("name").String(), "MapFromPairsList name")
+  assertSoyDataEquals(t, NewBooleanData(true), m.Get("1"), "MapFromPairsList converts keys to strings")
+
+  m, err = MapFromPairsList(NewSoyListDataFromArgs("a", 1, "b"))
+  if err == nil {
+    t.Errorf("MapFromPairsList of a three element list should fail but returned %v", m)
+  }
+  m, err = MapFromPairsList(NewSoyListData())
+  if err != nil || m.Len() != 0 {
+    t.Errorf("MapFromPairsList of an empty list should be an empty map but was %v, %v", m, err)
+  }
+  for _, key := range []SoyData{NilDataInstance, UndefinedDataInstance} {
+    m, err = MapFromPairsList(NewSoyListDataFromArgs("a", 1, key, 2))
+    if err == nil {
+      t.Errorf("MapFromPairsList with a %v key should fail but returned %v", key, m)
+    }
+  }
+}
+
+func TestStrPad(t *testing.T) {
+  assertStringEquals(t, "007", StrPadStart(NewIntegerData(7), 3, "0").Value(), "StrPadStart(7, 3, \"0\")")
+  assertStringEquals(t, "7  ", StrPadEnd(NewStringData("7"), 3, " ").Value(), "StrPadEnd(\"7\", 3, \" \")")
+  assertStringEquals(t, "12345", StrPadStart(NewIntegerData(12345), 3, "0").Value(), "StrPadStart of a longer string")
+  assertStringEquals(t, "12345", StrPadEnd(NewIntegerData(12345), 3, "0").Value(), "StrPadEnd of a longer string")
+  assertStringEquals(t, "abax", StrPadStart(NewStringData("x"), 4, "ab").Value(), "StrPadStart cuts the pad short")
+  assertStringEquals(t, "xaba", StrPadEnd(NewStringData("x"), 4, "ab").Value(), "StrPadEnd cuts the pad short")
+  assertStringEquals(t, "ééé", StrPadStart(NewStringData("é"), 3, "é").Value(), "StrPadStart counts characters, not bytes")
+  assertStringEquals(t, "x", StrPadStart(NewStringData("x"), 4, "").Value(), "StrPadStart with an empty pad")
+}
+
+func TestAsBool(t *testing.T) {
+  b, ok := AsBool(NewBooleanData(true))
+  assertBoolEquals(t, true, b, "AsBool(true) value")
+  assertBoolEquals(t, true, ok, "AsBool(true) is a bool")
+  b, ok = AsBool(NewBooleanData(false))
+  assertBoolEquals(t, false, b, "AsBool(false) value")
+  assertBoolEquals(t, true, ok, "AsBool(false) is a bool")
+  _, ok = AsBool(NewIntegerData(1))
+  assertBoolEquals(t, false, ok, "AsBool(1) is a bool")
+  _, ok = AsBool(NewStringData("true"))
+  assertBoolEquals(t, false, ok, "AsBool(\"true\") is a bool")
+  b, ok = AsBool(NewSanitizedContent("false", CONTENT_KIND_HTML))
+  assertBoolEquals(t, false, b, "AsBool of \"false\" content value")
+  assertBoolEquals(t, true, ok, "AsBool of \"false\" content is a bool")
+  b, ok = AsBool(NewSanitizedContent("true", CONTENT_KIND_HTML))
+  assertBoolEquals(t, true, b && ok, "AsBool of \"true\" content")
+  _, ok = AsBool(NewSanitizedContent("yes", CONTENT_KIND_HTML))
+  assertBoolEquals(t, false, ok, "AsBool of \"yes\" content is a bool")
+  _, ok = AsBool(nil)
+  assertBoolEquals(t, false, ok, "AsBool(nil) is a bool")
+  _, ok = AsBool(NilDataInstance)
+  assertBoolEquals(t, false, ok, "AsBool(NilData) is a bool")
+}